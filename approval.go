@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =============================================================================
+// Approval Gates
+// =============================================================================
+
+// approvalDecision is a decision submitted for a build waiting at its gate
+type approvalDecision struct {
+	approved bool
+	user     string
+	comment  string
+}
+
+// approvalError reports a build that was rejected or not approved in time.
+// It fails the build even when the gated step has continue_on_error set.
+type approvalError struct {
+	reason string
+}
+
+func (e *approvalError) Error() string {
+	return e.reason
+}
+
+// ApprovalGates tracks the builds currently waiting for an approval decision
+type ApprovalGates struct {
+	mu      sync.Mutex
+	pending map[string]chan approvalDecision
+}
+
+// NewApprovalGates creates an empty set of approval gates
+func NewApprovalGates() *ApprovalGates {
+	return &ApprovalGates{
+		pending: make(map[string]chan approvalDecision),
+	}
+}
+
+// Open starts waiting for a decision on a build
+func (g *ApprovalGates) Open(buildID string) <-chan approvalDecision {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	decisions := make(chan approvalDecision, 1)
+	g.pending[buildID] = decisions
+	return decisions
+}
+
+// Close stops waiting for a decision on a build
+func (g *ApprovalGates) Close(buildID string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.pending, buildID)
+}
+
+// Decide delivers a decision to a waiting build. It reports false if the
+// build is not waiting or a decision has already been made.
+func (g *ApprovalGates) Decide(buildID string, decision approvalDecision) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	decisions, exists := g.pending[buildID]
+	if !exists {
+		return false
+	}
+	select {
+	case decisions <- decision:
+		delete(g.pending, buildID)
+		return true
+	default:
+		return false
+	}
+}
+
+// =============================================================================
+// Approval Flow
+// =============================================================================
+
+// needsApproval reports whether a step of the build must be approved first.
+// Push and deploy steps of release branches are gated.
+func (run *buildRun) needsApproval(step PipelineStep) bool {
+	if step.Type != StepTypePush && step.Type != StepTypeDeploy {
+		return false
+	}
+	return run.git.isReleaseBranch(run.req.Branch)
+}
+
+// awaitApproval blocks a gated step until the build has been approved. The
+// first gated step opens the gate; the decision then applies to every other
+// gated step of the build. runPipeline opens the gate itself once no other
+// step is running, so gated steps find it decided.
+func (bm *BuildManager) awaitApproval(run *buildRun, step PipelineStep) error {
+	if !run.needsApproval(step) {
+		return nil
+	}
+	run.approvalOnce.Do(func() {
+		run.approvalErr = bm.requestApproval(run, step)
+	})
+	return run.approvalErr
+}
+
+// requestApproval pauses the build until someone approves or rejects it, the
+// configured approval timeout rejects it, or the build is cancelled. No step
+// of the build may be running: while it waits the build gives up its queue
+// slot and branch lock, and once approved it queues again ahead of the
+// waiting builds. A rejected build does not queue again, as it fails without
+// running any further step.
+func (bm *BuildManager) requestApproval(run *buildRun, step PipelineStep) error {
+	decisions := bm.approvals.Open(run.build.ID())
+	defer bm.approvals.Close(run.build.ID())
+
+	now := time.Now()
+	approval := ApprovalRecord{Step: step.Name, Status: ApprovalPending, RequestedAt: &now}
+	run.setApproval(approval)
+	run.setStatus(BuildStatusAwaiting, "")
+	bm.queue.Pause(run)
+	run.log(fmt.Sprintf("✋ 發布分支 %s 的步驟 %s 需要核准，等待核准中...", run.req.Branch, step.Name), "warning")
+
+	var expired <-chan time.Time
+	timeout := time.Duration(bm.config.Build.ApprovalTimeoutMinutes) * time.Minute
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	ctx := run.build.Context()
+	var err error
+	select {
+	case decision := <-decisions:
+		approval.DecidedBy = decision.user
+		approval.Comment = decision.comment
+		if decision.approved {
+			approval.Status = ApprovalApproved
+		} else {
+			approval.Status = ApprovalRejected
+			err = &approvalError{reason: fmt.Sprintf("approval rejected by %s", decision.user)}
+		}
+	case <-expired:
+		approval.Status = ApprovalRejected
+		approval.Comment = fmt.Sprintf("no decision within %s", timeout)
+		err = &approvalError{reason: fmt.Sprintf("approval timed out after %s", timeout)}
+	case <-ctx.Done():
+		approval.Status = ApprovalCancelled
+		err = ctx.Err()
+	}
+
+	decidedAt := time.Now()
+	approval.DecidedAt = &decidedAt
+	run.setApproval(approval)
+	if ctx.Err() != nil {
+		return err
+	}
+
+	switch {
+	case err == nil:
+		run.log(fmt.Sprintf("✅ 已由 %s 核准，等待構建槽位...", approval.DecidedBy), "success")
+	case approval.DecidedBy != "":
+		run.log(fmt.Sprintf("⛔ 已由 %s 拒絕: %s", approval.DecidedBy, approval.Comment), "error")
+	default:
+		run.log(fmt.Sprintf("⛔ 超過 %s 未核准，自動拒絕", timeout), "error")
+	}
+	if err != nil {
+		return err
+	}
+
+	run.setStatus(BuildStatusQueued, "")
+	if err := bm.queue.Resume(ctx, run); err != nil {
+		return err
+	}
+	run.setStatus(BuildStatusRunning, "")
+	return nil
+}
+
+// decideApproval records an approval decision for a build waiting at its
+// gate. The requester cannot decide on their own build, and when the Git
+// configuration lists approvers only they can.
+func (bm *BuildManager) decideApproval(buildID string, decision approvalDecision) error {
+	build, exists := bm.builds.Get(buildID)
+	if !exists {
+		return fmt.Errorf("build %s not found", buildID)
+	}
+	record := build.Snapshot()
+	if record.Status != BuildStatusAwaiting {
+		return fmt.Errorf("build %s is not waiting for approval", buildID)
+	}
+
+	if decision.user == "" {
+		return fmt.Errorf("approver name is required")
+	}
+	if strings.EqualFold(decision.user, record.Request.User) {
+		return fmt.Errorf("%s requested the build and cannot approve it", decision.user)
+	}
+	if gitManager, exists := bm.gitManagerFor(record.Request.GitConfig); exists {
+		if approvers := gitManager.Approvers(); len(approvers) > 0 && !containsFold(approvers, decision.user) {
+			return fmt.Errorf("%s is not an approver for %s", decision.user, record.Request.GitConfig)
+		}
+	}
+
+	if !bm.approvals.Decide(buildID, decision) {
+		return fmt.Errorf("build %s is not waiting for approval", buildID)
+	}
+	return nil
+}
+
+// containsFold reports whether values contains value, ignoring case
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
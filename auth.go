@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// =============================================================================
+// Git Authentication
+// =============================================================================
+
+// Authentication modes of a Git configuration
+const (
+	AuthModeToken = "token" // HTTPS with a personal access token as password
+	AuthModeBasic = "basic" // HTTPS with a user name and password
+	AuthModeSSH   = "ssh"   // SSH with a private key file
+	AuthModeNone  = "none"  // Public repository
+)
+
+// defaultTokenUsername is sent with a token when no username is configured;
+// GitLab and GitHub accept any user name together with an access token
+const defaultTokenUsername = "oauth2"
+
+// credentialHelper answers git's credential requests from the environment, so
+// credentials never end up in URLs, the mirror's git config or process
+// arguments
+const credentialHelper = `!f() { test "$1" = get || exit 0; echo "username=${BUILD_TOOL_GIT_USERNAME}"; echo "password=${BUILD_TOOL_GIT_PASSWORD}"; }; f`
+
+// authMode returns the configured authentication mode. Without auth_mode a
+// configuration with a token uses token auth and one without uses none.
+func (gm *GitManager) authMode() string {
+	if gm.config.AuthMode != "" {
+		return gm.config.AuthMode
+	}
+	if gm.config.Token != "" {
+		return AuthModeToken
+	}
+	return AuthModeNone
+}
+
+// validateAuth checks that the settings the authentication mode needs are present
+func (gm *GitManager) validateAuth() error {
+	switch mode := gm.authMode(); mode {
+	case AuthModeToken, AuthModeBasic:
+		if _, err := credentialScope(gm.config.URL); err != nil {
+			return fmt.Errorf("%s auth: %v", mode, err)
+		}
+		if mode == AuthModeToken && gm.config.Token == "" {
+			return fmt.Errorf("token auth needs a token")
+		}
+		if mode == AuthModeBasic && (gm.config.Username == "" || gm.config.Password == "") {
+			return fmt.Errorf("basic auth needs a username and password")
+		}
+	case AuthModeSSH:
+		if gm.config.SSHKeyFile == "" {
+			return fmt.Errorf("ssh auth needs an ssh_key_file")
+		}
+		if _, err := os.Stat(gm.config.SSHKeyFile); err != nil {
+			return fmt.Errorf("ssh auth: %v", err)
+		}
+		if gm.config.KnownHostsFile != "" {
+			if _, err := os.Stat(gm.config.KnownHostsFile); err != nil {
+				return fmt.Errorf("ssh auth: %v", err)
+			}
+		}
+	case AuthModeNone:
+	default:
+		return fmt.Errorf("unknown auth_mode %q, expected %s, %s, %s or %s", mode, AuthModeToken, AuthModeBasic, AuthModeSSH, AuthModeNone)
+	}
+	return nil
+}
+
+// gitEnv returns the environment that authenticates git commands against the
+// configuration's repository. It is used for the mirror and passed to build
+// scripts so the git commands they run authenticate the same way.
+func (gm *GitManager) gitEnv() []string {
+	env := []string{"GIT_TERMINAL_PROMPT=0"} // Fail instead of waiting for input
+
+	switch gm.authMode() {
+	case AuthModeToken:
+		username := gm.config.Username
+		if username == "" {
+			username = defaultTokenUsername
+		}
+		env = append(env, gm.credentialEnv(username, gm.config.Token)...)
+		env = append(env, "GITLAB_TOKEN="+gm.config.Token, "GIT_TOKEN="+gm.config.Token)
+	case AuthModeBasic:
+		env = append(env, gm.credentialEnv(gm.config.Username, gm.config.Password)...)
+	case AuthModeSSH:
+		sshCommand := "ssh -i " + shellQuote(gm.config.SSHKeyFile) + " -o IdentitiesOnly=yes -o BatchMode=yes"
+		if gm.config.KnownHostsFile != "" {
+			sshCommand += " -o UserKnownHostsFile=" + shellQuote(gm.config.KnownHostsFile) + " -o StrictHostKeyChecking=yes"
+		}
+		env = append(env, "GIT_SSH_COMMAND="+sshCommand)
+	}
+	return env
+}
+
+// credentialEnv installs credentialHelper for the repository's host through
+// GIT_CONFIG_* variables, replacing any helper configured on the machine
+func (gm *GitManager) credentialEnv(username, password string) []string {
+	scope, err := credentialScope(gm.config.URL)
+	if err != nil {
+		return nil
+	}
+	key := "credential." + scope + ".helper"
+	return []string{
+		"GIT_CONFIG_COUNT=2",
+		"GIT_CONFIG_KEY_0=" + key,
+		"GIT_CONFIG_VALUE_0=", // An empty helper clears the inherited ones
+		"GIT_CONFIG_KEY_1=" + key,
+		"GIT_CONFIG_VALUE_1=" + credentialHelper,
+		"BUILD_TOOL_GIT_USERNAME=" + username,
+		"BUILD_TOOL_GIT_PASSWORD=" + password,
+	}
+}
+
+// credentialScope returns the scheme and host of an HTTP(S) repository URL,
+// which limits the credentials to that host
+func credentialScope(repoURL string) (string, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", fmt.Errorf("repository URL %q is not an http(s) URL", repoURL)
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+// shellQuote quotes s for use as a single word in a shell command
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// =============================================================================
+// Data Structures
+// =============================================================================
+
+// BuildStatus represents the lifecycle state of a build or build step
+type BuildStatus string
+
+const (
+	BuildStatusPending   BuildStatus = "pending"
+	BuildStatusQueued    BuildStatus = "queued"
+	BuildStatusRunning   BuildStatus = "running"
+	BuildStatusAwaiting  BuildStatus = "awaiting_approval"
+	BuildStatusCompleted BuildStatus = "completed"
+	BuildStatusFailed    BuildStatus = "failed"
+	BuildStatusCancelled BuildStatus = "cancelled"
+	BuildStatusTimedOut  BuildStatus = "timed_out"
+	BuildStatusSkipped   BuildStatus = "skipped"
+)
+
+// IsFinal reports whether the status is terminal
+func (s BuildStatus) IsFinal() bool {
+	switch s {
+	case BuildStatusCompleted, BuildStatusFailed, BuildStatusCancelled, BuildStatusTimedOut:
+		return true
+	}
+	return false
+}
+
+// Names of the steps in the default pipeline
+const (
+	StepPull   = "pull"
+	StepBuild  = "build"
+	StepPush   = "push"
+	StepDeploy = "deploy"
+)
+
+// StepResult records the outcome of a single build step
+type StepResult struct {
+	Name       string      `json:"name"`
+	Status     BuildStatus `json:"status"`
+	StartedAt  *time.Time  `json:"started_at,omitempty"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Attempts   int         `json:"attempts,omitempty"` // Attempts made under the step's retry policy
+
+	Platforms []PlatformResult `json:"platforms,omitempty"` // Per-platform results of a matrix step
+	Rollback  *RollbackResult  `json:"rollback,omitempty"`  // Rollback run after a deploy step failed
+}
+
+// PlatformResult records the outcome of one platform of a matrix step
+type PlatformResult struct {
+	Platform   string      `json:"platform"`
+	Status     BuildStatus `json:"status"`
+	StartedAt  *time.Time  `json:"started_at,omitempty"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Attempts   int         `json:"attempts,omitempty"`
+}
+
+// RollbackResult records the rollback run after a deploy step failed
+type RollbackResult struct {
+	Status        BuildStatus `json:"status"`
+	StartedAt     *time.Time  `json:"started_at,omitempty"`
+	FinishedAt    *time.Time  `json:"finished_at,omitempty"`
+	Error         string      `json:"error,omitempty"`
+	TargetBuildID string      `json:"target_build_id,omitempty"` // Build whose deployment is restored
+	TargetCommit  string      `json:"target_commit,omitempty"`
+	TargetVersion string      `json:"target_version,omitempty"`
+}
+
+// Approval decisions of a build waiting at an approval gate
+const (
+	ApprovalPending   = "pending"
+	ApprovalApproved  = "approved"
+	ApprovalRejected  = "rejected"
+	ApprovalCancelled = "cancelled"
+)
+
+// ApprovalRecord records the approval a release build waited for
+type ApprovalRecord struct {
+	Step        string     `json:"step"` // Step that requested the approval
+	Status      string     `json:"status"`
+	RequestedAt *time.Time `json:"requested_at,omitempty"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+	DecidedBy   string     `json:"decided_by,omitempty"`
+	Comment     string     `json:"comment,omitempty"`
+}
+
+// BuildRecord is the serializable state of a build
+type BuildRecord struct {
+	ID            string          `json:"id"`
+	Status        BuildStatus     `json:"status"`
+	Request       BuildRequest    `json:"request"`
+	Progress      int             `json:"progress"`
+	QueuePosition int             `json:"queue_position,omitempty"`
+	Pipeline      []PipelineStep  `json:"pipeline,omitempty"`
+	Steps         []StepResult    `json:"steps"`
+	Approval      *ApprovalRecord `json:"approval,omitempty"`
+	ParentID      string          `json:"parent_id,omitempty"`     // Build this one re-runs or promotes
+	RerunFrom     string          `json:"rerun_from,omitempty"`    // Step the re-run started from
+	PromotedFrom  string          `json:"promoted_from,omitempty"` // Environment a promotion started from
+	CommitHash    string          `json:"commit_hash,omitempty"`
+	Version       string          `json:"version,omitempty"` // Docker tag from versions.json
+	Workspace     string          `json:"workspace,omitempty"`
+	Error         string          `json:"error,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	StartedAt     *time.Time      `json:"started_at,omitempty"`
+	FinishedAt    *time.Time      `json:"finished_at,omitempty"`
+	DurationMs    int64           `json:"duration_ms,omitempty"`
+}
+
+// Build is a build job tracked by the build manager
+type Build struct {
+	id     string
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.RWMutex
+	record  BuildRecord
+	expired bool // Cancelled because the build exceeded its deadline
+}
+
+// BuildStore keeps track of the builds that are queued or running. Finished
+// builds are removed once their final state is in the history store.
+type BuildStore struct {
+	mu     sync.RWMutex
+	builds map[string]*Build
+}
+
+// =============================================================================
+// Build
+// =============================================================================
+
+// NewBuild creates a new pending build running the given pipeline steps
+func NewBuild(req BuildRequest, pipeline []PipelineStep) *Build {
+	id := newBuildID()
+
+	steps := []StepResult{}
+	for _, step := range pipeline {
+		result := StepResult{Name: step.Name, Status: BuildStatusPending}
+		for _, platform := range step.Platforms {
+			result.Platforms = append(result.Platforms, PlatformResult{Platform: platform, Status: BuildStatusPending})
+		}
+		steps = append(steps, result)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Build{
+		id:     id,
+		ctx:    ctx,
+		cancel: cancel,
+		record: BuildRecord{
+			ID:         id,
+			Status:     BuildStatusPending,
+			Request:    req,
+			Pipeline:   pipeline,
+			Steps:      steps,
+			CommitHash: req.Commit,
+			CreatedAt:  time.Now(),
+		},
+	}
+}
+
+// ID returns the build identifier
+func (b *Build) ID() string {
+	return b.id
+}
+
+// Context returns the build context, which is cancelled when the build is stopped
+func (b *Build) Context() context.Context {
+	return b.ctx
+}
+
+// Cancel requests cancellation of a pending or running build
+func (b *Build) Cancel() error {
+	if b.IsFinished() {
+		return fmt.Errorf("build %s has already finished", b.id)
+	}
+	b.cancel()
+	return nil
+}
+
+// Expire cancels a build that exceeded its deadline
+func (b *Build) Expire() {
+	b.mu.Lock()
+	if b.record.Status.IsFinal() {
+		b.mu.Unlock()
+		return
+	}
+	b.expired = true
+	b.mu.Unlock()
+	b.cancel()
+}
+
+// Expired reports whether the build was stopped by its deadline
+func (b *Build) Expired() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.expired
+}
+
+// interruptedStatus returns the status of work stopped by the build's
+// cancellation: timed out if the deadline expired, cancelled otherwise
+func (b *Build) interruptedStatus() BuildStatus {
+	if b.Expired() {
+		return BuildStatusTimedOut
+	}
+	return BuildStatusCancelled
+}
+
+// IsFinished reports whether the build has reached a terminal status
+func (b *Build) IsFinished() bool {
+	return b.Status().IsFinal()
+}
+
+// Snapshot returns a copy of the current build state
+func (b *Build) Snapshot() BuildRecord {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	record := b.record
+	record.Steps = append([]StepResult(nil), b.record.Steps...)
+	for i := range record.Steps {
+		record.Steps[i].Platforms = append([]PlatformResult(nil), record.Steps[i].Platforms...)
+	}
+	return record
+}
+
+// Status returns the current build status
+func (b *Build) Status() BuildStatus {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.record.Status
+}
+
+// update applies fn to the build record while holding the lock
+func (b *Build) update(fn func(r *BuildRecord)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	fn(&b.record)
+}
+
+// setStatus transitions the build to a new status and stamps timestamps
+func (b *Build) setStatus(status BuildStatus, errMsg string) {
+	b.update(func(r *BuildRecord) {
+		now := time.Now()
+		r.Status = status
+		if errMsg != "" {
+			r.Error = errMsg
+		}
+		switch {
+		case status == BuildStatusRunning && r.StartedAt == nil:
+			r.StartedAt = &now
+		case status.IsFinal():
+			r.FinishedAt = &now
+			if r.StartedAt != nil {
+				r.DurationMs = now.Sub(*r.StartedAt).Milliseconds()
+			}
+		}
+	})
+	if b.IsFinished() {
+		b.cancel() // Release context resources
+	}
+}
+
+// setCommitHash records the commit the build is based on
+func (b *Build) setCommitHash(hash string) {
+	b.update(func(r *BuildRecord) {
+		r.CommitHash = hash
+	})
+}
+
+// setParent links a re-run to the build it repeats and to that build's commit
+func (b *Build) setParent(parent BuildRecord, fromStep string) {
+	b.update(func(r *BuildRecord) {
+		r.ParentID = parent.ID
+		r.RerunFrom = fromStep
+		r.CommitHash = parent.CommitHash
+		r.Version = parent.Version
+	})
+}
+
+// setPromotion links a promotion to the deployed build it redeploys
+func (b *Build) setPromotion(parent BuildRecord) {
+	b.setParent(parent, "")
+	b.update(func(r *BuildRecord) {
+		r.PromotedFrom = parent.Request.Environment
+	})
+}
+
+// setApproval records the state of the build's approval gate. Each call
+// stores a new copy, so snapshots never share a record that is still changing.
+func (b *Build) setApproval(approval ApprovalRecord) {
+	b.update(func(r *BuildRecord) {
+		r.Approval = &approval
+	})
+}
+
+// setVersion records the version being built
+func (b *Build) setVersion(version string) {
+	b.update(func(r *BuildRecord) {
+		r.Version = version
+	})
+}
+
+// setWorkspace records the directory the build runs in
+func (b *Build) setWorkspace(dir string) {
+	b.update(func(r *BuildRecord) {
+		r.Workspace = dir
+	})
+}
+
+// setQueuePosition records the position of a queued build and reports
+// whether it changed
+func (b *Build) setQueuePosition(position int) bool {
+	changed := false
+	b.update(func(r *BuildRecord) {
+		changed = r.QueuePosition != position
+		r.QueuePosition = position
+	})
+	return changed
+}
+
+// setProgress records the build progress percentage
+func (b *Build) setProgress(progress int) {
+	b.update(func(r *BuildRecord) {
+		r.Progress = progress
+	})
+}
+
+// startStep marks a step as running
+func (b *Build) startStep(name string) {
+	b.update(func(r *BuildRecord) {
+		if step := r.step(name); step != nil {
+			now := time.Now()
+			step.Status = BuildStatusRunning
+			step.StartedAt = &now
+		}
+	})
+}
+
+// finishStep marks a step as completed or failed
+func (b *Build) finishStep(name string, status BuildStatus, errMsg string) {
+	b.update(func(r *BuildRecord) {
+		if step := r.step(name); step != nil {
+			now := time.Now()
+			step.Status = status
+			step.FinishedAt = &now
+			step.Error = errMsg
+		}
+	})
+}
+
+// startPlatform marks one platform of a matrix step as running
+func (b *Build) startPlatform(name, platform string) {
+	b.update(func(r *BuildRecord) {
+		if result := r.platform(name, platform); result != nil {
+			now := time.Now()
+			result.Status = BuildStatusRunning
+			result.StartedAt = &now
+		}
+	})
+}
+
+// setStepAttempt records the attempt a step is on
+func (b *Build) setStepAttempt(name string, attempt int) {
+	b.update(func(r *BuildRecord) {
+		if step := r.step(name); step != nil {
+			step.Attempts = attempt
+		}
+	})
+}
+
+// setPlatformAttempt records the attempt one platform of a matrix step is on
+func (b *Build) setPlatformAttempt(name, platform string, attempt int) {
+	b.update(func(r *BuildRecord) {
+		if result := r.platform(name, platform); result != nil {
+			result.Attempts = attempt
+		}
+	})
+}
+
+// setRollback records the rollback of a failed deploy step. Each call stores
+// a new copy, so snapshots never share a result that is still changing.
+func (b *Build) setRollback(name string, rollback RollbackResult) {
+	b.update(func(r *BuildRecord) {
+		if step := r.step(name); step != nil {
+			step.Rollback = &rollback
+		}
+	})
+}
+
+// finishPlatform records the outcome of one platform of a matrix step
+func (b *Build) finishPlatform(name, platform string, status BuildStatus, errMsg string) {
+	b.update(func(r *BuildRecord) {
+		if result := r.platform(name, platform); result != nil {
+			now := time.Now()
+			result.Status = status
+			result.FinishedAt = &now
+			result.Error = errMsg
+		}
+	})
+}
+
+// skipPendingSteps marks every step and platform that has not started as skipped
+func (b *Build) skipPendingSteps() {
+	b.update(func(r *BuildRecord) {
+		for i := range r.Steps {
+			if r.Steps[i].Status == BuildStatusPending {
+				r.Steps[i].Status = BuildStatusSkipped
+			}
+			for j := range r.Steps[i].Platforms {
+				if r.Steps[i].Platforms[j].Status == BuildStatusPending {
+					r.Steps[i].Platforms[j].Status = BuildStatusSkipped
+				}
+			}
+		}
+	})
+}
+
+// step finds a step result by name
+func (r *BuildRecord) step(name string) *StepResult {
+	for i := range r.Steps {
+		if r.Steps[i].Name == name {
+			return &r.Steps[i]
+		}
+	}
+	return nil
+}
+
+// deployed reports whether the build ran deploy steps and all of them
+// completed without being rolled back
+func (r *BuildRecord) deployed() bool {
+	found := false
+	for _, step := range r.Pipeline {
+		if step.Type != StepTypeDeploy {
+			continue
+		}
+		result := r.step(step.Name)
+		if result == nil || result.Status != BuildStatusCompleted {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// platform finds the result of one platform of a matrix step
+func (r *BuildRecord) platform(name, platform string) *PlatformResult {
+	step := r.step(name)
+	if step == nil {
+		return nil
+	}
+	for i := range step.Platforms {
+		if step.Platforms[i].Platform == platform {
+			return &step.Platforms[i]
+		}
+	}
+	return nil
+}
+
+// newBuildID generates a sortable, unique build identifier
+func newBuildID() string {
+	buf := make([]byte, 3)
+	if _, err := rand.Read(buf); err != nil {
+		return time.Now().Format("20060102-150405.000000")
+	}
+	return fmt.Sprintf("%s-%s", time.Now().Format("20060102-150405"), hex.EncodeToString(buf))
+}
+
+// =============================================================================
+// Build Store
+// =============================================================================
+
+// NewBuildStore creates an empty build store
+func NewBuildStore() *BuildStore {
+	return &BuildStore{
+		builds: make(map[string]*Build),
+	}
+}
+
+// Add registers a build in the store
+func (s *BuildStore) Add(build *Build) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.builds[build.ID()] = build
+}
+
+// Get looks up a build by ID
+func (s *BuildStore) Get(id string) (*Build, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	build, ok := s.builds[id]
+	return build, ok
+}
+
+// Remove drops a build from the store
+func (s *BuildStore) Remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.builds, id)
+}
+
+// Active returns the builds that have not finished yet
+func (s *BuildStore) Active() []*Build {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	active := []*Build{}
+	for _, build := range s.builds {
+		if !build.IsFinished() {
+			active = append(active, build)
+		}
+	}
+	return active
+}
@@ -10,6 +10,7 @@ import (
 // Config represents the application configuration
 type Config struct {
 	Server     ServerConfig           `json:"server"`
+	Build      BuildConfig            `json:"build"`
 	GitConfigs map[string]GitConfig   `json:"git_configs"`
 }
 
@@ -20,11 +21,33 @@ type ServerConfig struct {
 	WriteTimeout int    `json:"write_timeout"`
 }
 
+// BuildConfig represents build execution and storage settings
+type BuildConfig struct {
+	DataDir                 string `json:"data_dir"`                  // Directory for build history and logs
+	LogRetentionDays        int    `json:"log_retention_days"`        // Days to keep build logs, 0 keeps them forever
+	MaxConcurrent           int    `json:"max_concurrent"`            // Maximum number of builds running at once
+	WorkspaceRetentionHours int    `json:"workspace_retention_hours"` // Hours to keep finished build workspaces, 0 removes them immediately
+	MaxParallelSteps        int    `json:"max_parallel_steps"`        // Maximum number of pipeline steps of a build running at once
+	StepTimeoutSeconds      int    `json:"step_timeout_seconds"`      // Default step timeout, overridable per step; 0 means no limit
+	BuildTimeoutSeconds     int    `json:"build_timeout_seconds"`     // Deadline for a whole build once it starts; 0 means no limit
+	ApprovalTimeoutMinutes  int    `json:"approval_timeout_minutes"`  // Time a release build waits for approval before it is rejected; 0 waits forever
+}
+
 // GitConfig represents Git repository configuration
 type GitConfig struct {
-	URL         string `json:"url"`
-	Token       string `json:"token"`       // PAT token for authentication
-	Description string `json:"description"` // Human-readable description
+	URL         string   `json:"url"`
+	Token       string   `json:"token"`       // PAT token for authentication
+	Description string   `json:"description"` // Human-readable description
+	Approvers   []string `json:"approvers"`   // Users allowed to approve release builds; empty allows anyone but the requester
+
+	AuthMode       string `json:"auth_mode"`        // token, basic, ssh or none; empty uses token when a token is set, otherwise none
+	Username       string `json:"username"`         // User name for basic auth, or sent with the token (default oauth2)
+	Password       string `json:"password"`         // Password for basic auth
+	SSHKeyFile     string `json:"ssh_key_file"`     // Private key file for ssh auth
+	KnownHostsFile string `json:"known_hosts_file"` // known_hosts file for ssh auth; empty uses the user's default
+
+	FetchIntervalSeconds int    `json:"fetch_interval_seconds"` // Seconds before reads fetch the repository mirror again, 0 uses 60
+	BaseBranch           string `json:"base_branch"`            // Branch the branch list counts commits ahead/behind against, empty uses dev
 }
 
 // DefaultConfig returns the default configuration
@@ -35,6 +58,15 @@ func DefaultConfig() *Config {
 			ReadTimeout:  15,
 			WriteTimeout: 15,
 		},
+		Build: BuildConfig{
+			DataDir:                "data",
+			LogRetentionDays:       30,
+			MaxConcurrent:          2,
+			MaxParallelSteps:       4,
+			StepTimeoutSeconds:     3600,
+			BuildTimeoutSeconds:    10800,
+			ApprovalTimeoutMinutes: 60,
+		},
 		GitConfigs: map[string]GitConfig{
 			"main": {
 				URL:         "https://github.com/your-org/build-scripts.git",
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+)
+
+// =============================================================================
+// Deployment Environments
+// =============================================================================
+
+// resolveEnvironment validates a requested target environment against
+// deployment.environments. An empty request selects the first environment.
+func (d DeploymentConfig) resolveEnvironment(requested string) (string, error) {
+	if requested == "" {
+		if len(d.Environments) == 0 {
+			return "", nil
+		}
+		return d.Environments[0], nil
+	}
+	if d.environmentIndex(requested) < 0 {
+		return "", fmt.Errorf("unknown environment %q, expected one of %v", requested, d.Environments)
+	}
+	return requested, nil
+}
+
+// nextEnvironment returns the environment following current in
+// deployment.environments
+func (d DeploymentConfig) nextEnvironment(current string) (string, error) {
+	index := d.environmentIndex(current)
+	if index < 0 {
+		return "", fmt.Errorf("unknown environment %q, expected one of %v", current, d.Environments)
+	}
+	if index == len(d.Environments)-1 {
+		return "", fmt.Errorf("environment %s is the last one, nothing to promote to", current)
+	}
+	return d.Environments[index+1], nil
+}
+
+// environmentIndex returns the position of an environment, or -1
+func (d DeploymentConfig) environmentIndex(environment string) int {
+	for i, name := range d.Environments {
+		if name == environment {
+			return i
+		}
+	}
+	return -1
+}
+
+// hasDeploySteps reports whether any of the steps deploys
+func hasDeploySteps(steps []PipelineStep) bool {
+	for _, step := range steps {
+		if step.Type == StepTypeDeploy {
+			return true
+		}
+	}
+	return false
+}
+
+// =============================================================================
+// Promotion
+// =============================================================================
+
+// promoteBuild starts a new build that runs only the deploy steps of a
+// successfully deployed build against another environment, reusing its
+// commit and version instead of building again. Without an explicit
+// environment the next one in deployment.environments is the target.
+func (bm *BuildManager) promoteBuild(parentID, environment, user string, sub EventSubscriber) (*Build, error) {
+	parent, exists := bm.lookupBuild(parentID)
+	if !exists {
+		return nil, fmt.Errorf("build %s not found", parentID)
+	}
+	if !parent.deployed() {
+		return nil, fmt.Errorf("build %s has no successful deployment to promote", parentID)
+	}
+
+	req := parent.Request
+	gitManager, exists := bm.gitManagerFor(req.GitConfig)
+	if !exists {
+		return nil, fmt.Errorf("git configuration %q not found", req.GitConfig)
+	}
+	// Deployment settings come from the commit the parent deployed, so a
+	// later change to the branch's environments does not alter the promotion
+	if parent.CommitHash == "" {
+		return nil, fmt.Errorf("build %s has no recorded commit to promote", parentID)
+	}
+	config, err := gitManager.GetBranchConfig(parent.CommitHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load deployment settings: %v", err)
+	}
+
+	if environment == "" {
+		environment, err = config.Deployment.nextEnvironment(req.Environment)
+	} else {
+		environment, err = config.Deployment.resolveEnvironment(environment)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if environment == req.Environment {
+		return nil, fmt.Errorf("build %s is already deployed to %s", parentID, environment)
+	}
+
+	steps := []PipelineStep{}
+	req.Steps = nil
+	for _, step := range parent.Pipeline {
+		if step.Type == StepTypeDeploy {
+			steps = append(steps, step)
+			req.Steps = append(req.Steps, step.Name)
+		}
+	}
+	req.Environment = environment
+	if user != "" {
+		req.User = user
+	}
+
+	build := NewBuild(req, steps)
+	build.setPromotion(parent)
+	reuseWorkspace(build, parent)
+	bm.launchBuild(build, gitManager, steps, sub)
+	return build, nil
+}
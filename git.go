@@ -1,17 +1,20 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"gopkg.in/yaml.v2"
 	"build-tool/config"
 )
@@ -20,26 +23,43 @@ import (
 // Data Structures
 // =============================================================================
 
-// GitManager handles Git operations
+// GitManager handles Git operations for a single Git configuration.
+// Its configuration is fixed at construction so a manager can be shared
+// safely between concurrent requests and builds.
 type GitManager struct {
-	currentConfig config.GitConfig
+	name   string
+	config config.GitConfig
+
+	mirrorMu  sync.Mutex
+	lastFetch time.Time // Last successful fetch of the mirror
 }
 
-// Branch represents a Git branch with metadata
+// Branch represents a Git branch or tag with metadata
 type Branch struct {
-	Name        string `json:"name"`
-	Date        string `json:"date"`
-	Description string `json:"description"`
-	CommitHash  string `json:"commit_hash"`
-	IsRelease   bool   `json:"is_release"`
+	Name        string    `json:"name"`
+	RefType     string    `json:"ref_type"` // RefTypeBranch or RefTypeTag
+	Date        string    `json:"date"`        // Date of the last commit
+	Description string    `json:"description"` // Subject line of the last commit
+	CommitHash  string    `json:"commit_hash"`
+	IsRelease   bool      `json:"is_release"`
+	CommittedAt time.Time `json:"committed_at"`
+	Author      string    `json:"author"`
+	BaseBranch  string    `json:"base_branch,omitempty"` // Branch that Ahead and Behind are counted against
+	Ahead       int       `json:"ahead"`                 // Commits on the branch that are not on the base branch
+	Behind      int       `json:"behind"`                // Commits on the base branch that are not on the branch
 }
 
+// defaultBaseBranch is the branch others are compared against when the Git
+// configuration sets no base_branch
+const defaultBaseBranch = "dev"
+
 // BranchConfig represents configuration from config.yaml
 type BranchConfig struct {
 	Project      ProjectConfig     `yaml:"project"`
 	Repositories RepositoryConfig  `yaml:"repositories"`
 	Build        BuildSettings     `yaml:"build"`
 	Deployment   DeploymentConfig  `yaml:"deployment"`
+	Pipeline     []PipelineStep    `yaml:"pipeline"`
 }
 
 // ProjectConfig contains project-level settings
@@ -64,6 +84,7 @@ type Module struct {
 // BuildSettings contains build-related configuration
 type BuildSettings struct {
 	Platforms       []string `yaml:"platforms"`
+	FailFast        *bool    `yaml:"fail_fast"` // Cancel other platforms once one fails, default true
 	GenerateSwagger bool     `yaml:"generate_swagger"`
 	ModulesDir      string   `yaml:"modules_dir"`
 	SwaggerCommand  string   `yaml:"swagger_command"`
@@ -71,9 +92,20 @@ type BuildSettings struct {
 
 // DeploymentConfig contains deployment settings
 type DeploymentConfig struct {
-	Environments         []string     `yaml:"environments"`
-	HealthCheckEndpoint  string       `yaml:"health_check_endpoint"`
-	Docker               DockerConfig `yaml:"docker"`
+	Environments         []string          `yaml:"environments"`
+	HealthCheckEndpoint  string            `yaml:"health_check_endpoint"` // May contain {host} and {environment}
+	HealthCheck          HealthCheckConfig `yaml:"health_check"`
+	Docker               DockerConfig      `yaml:"docker"`
+}
+
+// HealthCheckConfig controls how the health check endpoint is polled after a deploy
+type HealthCheckConfig struct {
+	Hosts          map[string]string `yaml:"hosts"`           // Host substituted for {host}, per environment
+	ExpectedStatus int               `yaml:"expected_status"` // Default 200
+	ExpectedBody   string            `yaml:"expected_body"`   // Text the response body must contain
+	Retries        int               `yaml:"retries"`         // Probes before giving up, default 10
+	Interval       int               `yaml:"interval"`        // Seconds between probes, default 5
+	Timeout        int               `yaml:"timeout"`         // Seconds for all probes together, default 300
 }
 
 // DockerConfig contains Docker-specific settings
@@ -100,98 +132,152 @@ type VersionInfo struct {
 // Constructor and Configuration
 // =============================================================================
 
-// NewGitManager creates a new Git manager
-func NewGitManager(defaultConfig config.GitConfig) *GitManager {
+// NewGitManager creates a new Git manager for the named configuration
+func NewGitManager(name string, cfg config.GitConfig) *GitManager {
 	return &GitManager{
-		currentConfig: defaultConfig,
+		name:   name,
+		config: cfg,
 	}
 }
 
-// UpdateConfig updates the Git configuration
-func (gm *GitManager) UpdateConfig(cfg config.GitConfig) {
-	gm.currentConfig = cfg
+// Name returns the name of the Git configuration
+func (gm *GitManager) Name() string {
+	return gm.name
+}
+
+// Approvers returns the users allowed to approve release builds
+func (gm *GitManager) Approvers() []string {
+	return gm.config.Approvers
 }
 
 // =============================================================================
 // Git Operations
 // =============================================================================
 
-// GetAllBranches fetches all branches from Git repository
+// GetAllBranches lists the branches of the repository mirror with their last
+// commit and how far they are ahead of and behind the base branch
 func (gm *GitManager) GetAllBranches() ([]Branch, error) {
-	log.Printf("Fetching branches from repository: %s", gm.currentConfig.URL)
+	return gm.listRefs("refs/heads/", RefTypeBranch)
+}
 
-	// Create Git URL with token if provided
-	gitURL := gm.createAuthenticatedURL()
+// GetAllTags lists the tags of the repository mirror with the commit they
+// point to and how far they are ahead of and behind the base branch
+func (gm *GitManager) GetAllTags() ([]Branch, error) {
+	return gm.listRefs("refs/tags/", RefTypeTag)
+}
 
-	// Execute git ls-remote to get remote branches
-	cmd := exec.Command("git", "ls-remote", "--heads", gitURL)
-	output, err := cmd.Output()
-	if err != nil {
+// listRefs lists the refs under prefix from the repository mirror
+func (gm *GitManager) listRefs(prefix, refType string) ([]Branch, error) {
+	if err := gm.refresh(); err != nil {
 		return nil, fmt.Errorf("failed to fetch remote branches: %v", err)
 	}
 
+	// Annotated tags are peeled to their commit; their tagger and message are shown
+	format := "--format=%(objectname)%09%(*objectname)%09%(refname)%09%(creatordate:iso-strict)%09" +
+		"%(if)%(taggername)%(then)%(taggername)%(else)%(authorname)%(end)%09%(contents:subject)"
+	output, err := gm.mirrorGit("for-each-ref", format, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %ss: %v", refType, err)
+	}
+
+	baseBranch := gm.baseBranch()
+	if _, err := gm.ResolveBranch(baseBranch); err != nil {
+		log.Printf("Base branch %s of %s not found, not counting ahead/behind", baseBranch, gm.name)
+		baseBranch = ""
+	}
+
 	branches := []Branch{}
 	lines := strings.Split(string(output), "\n")
 	
 	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
+		if strings.TrimSpace(line) == "" {
 			continue
 		}
 
-		// Parse git ls-remote output: "commit_hash refs/heads/branch_name"
-		parts := strings.Fields(line)
-		if len(parts) != 2 {
+		// Parse for-each-ref output: "hash<TAB>peeled hash<TAB>ref<TAB>date<TAB>author<TAB>subject"
+		parts := strings.SplitN(line, "\t", 6)
+		if len(parts) != 6 {
 			continue
 		}
 
 		commitHash := parts[0]
-		refName := parts[1]
+		if parts[1] != "" {
+			commitHash = parts[1]
+		}
+		refName := parts[2]
 		
-		// Extract branch name from refs/heads/branch_name
-		if !strings.HasPrefix(refName, "refs/heads/") {
+		// Extract the name from refs/heads/name or refs/tags/name
+		if !strings.HasPrefix(refName, prefix) {
 			continue
 		}
 		
-		branchName := strings.TrimPrefix(refName, "refs/heads/")
+		name := strings.TrimPrefix(refName, prefix)
 		
 		// Get detailed branch information
-		branchInfo := gm.createBranchInfo(branchName, commitHash)
+		branchInfo := gm.createBranchInfo(name, commitHash, parts[3], parts[4], parts[5])
+		branchInfo.RefType = refType
+		if baseBranch != "" {
+			gm.compareWithBase(&branchInfo, refName, baseBranch)
+		}
 		branches = append(branches, branchInfo)
 	}
 
-	log.Printf("Found %d branches", len(branches))
+	log.Printf("Found %d %ss", len(branches), refType)
 	return branches, nil
 }
 
-// CloneOrPullBranch clones a branch or pulls latest if already exists
-func (gm *GitManager) CloneOrPullBranch(branchName, targetDir string) error {
-	if _, err := os.Stat(targetDir); err == nil {
-		// Directory exists, pull latest changes
-		log.Printf("Updating existing repository: %s", targetDir)
-		cmd := exec.Command("git", "-C", targetDir, "pull", "origin", branchName)
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("failed to pull latest changes: %v\nOutput: %s", err, string(output))
-		}
-	} else {
-		// Directory doesn't exist, clone the branch
-		log.Printf("Cloning branch %s to %s", branchName, targetDir)
-		
-		// Create parent directory
-		if err := os.MkdirAll(filepath.Dir(targetDir), 0755); err != nil {
-			return fmt.Errorf("failed to create parent directory: %v", err)
-		}
-		
-		gitURL := gm.createAuthenticatedURL()
-		cmd := exec.Command("git", "clone", "-b", branchName, "--single-branch", gitURL, targetDir)
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("failed to clone branch %s: %v\nOutput: %s", branchName, err, string(output))
-		}
+// baseBranch returns the branch others are compared against
+func (gm *GitManager) baseBranch() string {
+	if gm.config.BaseBranch != "" {
+		return gm.config.BaseBranch
+	}
+	return defaultBaseBranch
+}
+
+// compareWithBase counts the commits a branch or tag is ahead of and behind
+// the base branch. Counting failures are logged and leave the counts unset.
+func (gm *GitManager) compareWithBase(branch *Branch, refName, baseBranch string) {
+	output, err := gm.mirrorGit("rev-list", "--left-right", "--count", "refs/heads/"+baseBranch+"..."+refName)
+	if err != nil {
+		log.Printf("Error comparing %s with %s: %v", branch.Name, baseBranch, err)
+		return
 	}
 
-	log.Printf("Successfully updated repository for branch %s", branchName)
+	// Output is "<behind> <ahead>": the left side counts commits only on the base
+	counts := strings.Fields(string(output))
+	if len(counts) != 2 {
+		return
+	}
+	behind, errBehind := strconv.Atoi(counts[0])
+	ahead, errAhead := strconv.Atoi(counts[1])
+	if errBehind != nil || errAhead != nil {
+		return
+	}
+	branch.BaseBranch = baseBranch
+	branch.Ahead = ahead
+	branch.Behind = behind
+}
+
+// CreateWorkspace clones the repository mirror into workspaceDir and checks
+// out commit, giving a build its own isolated working tree
+func (gm *GitManager) CreateWorkspace(workspaceDir, commit string) error {
+	if err := os.RemoveAll(workspaceDir); err != nil {
+		return fmt.Errorf("failed to clear workspace %s: %v", workspaceDir, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(workspaceDir), 0755); err != nil {
+		return fmt.Errorf("failed to create workspace parent directory: %v", err)
+	}
+
+	cmd := exec.Command("git", "clone", "--quiet", "--no-checkout", gm.mirrorDir(), workspaceDir)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create workspace: %v\nOutput: %s", err, string(output))
+	}
+
+	cmd = exec.Command("git", "-C", workspaceDir, "checkout", "--quiet", "--detach", commit)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(workspaceDir)
+		return fmt.Errorf("failed to check out commit %s: %v\nOutput: %s", commit, err, string(output))
+	}
 	return nil
 }
 
@@ -199,21 +285,27 @@ func (gm *GitManager) CloneOrPullBranch(branchName, targetDir string) error {
 // Branch File Operations
 // =============================================================================
 
-// GetBranchConfig reads config.yaml from a specific branch
-func (gm *GitManager) GetBranchConfig(branchName string) (*BranchConfig, error) {
-	// First ensure we have the latest version of the branch
-	targetDir := filepath.Join("repos", "temp", branchName)
-	if err := gm.CloneOrPullBranch(branchName, targetDir); err != nil {
-		return nil, fmt.Errorf("failed to get branch: %v", err)
+// GetBranchConfig reads config.yaml at a branch, tag or commit
+func (gm *GitManager) GetBranchConfig(ref string) (*BranchConfig, error) {
+	data, err := gm.ReadFile(ref, "config.yaml")
+	if err != nil {
+		return nil, err
 	}
+	return parseBranchConfig(data)
+}
 
-	// Read config.yaml
-	configPath := filepath.Join(targetDir, "config.yaml")
+// loadBranchConfig reads config.yaml from a checkout
+func loadBranchConfig(dir string) (*BranchConfig, error) {
+	configPath := filepath.Join(dir, "config.yaml")
 	data, err := ioutil.ReadFile(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config.yaml: %v", err)
 	}
+	return parseBranchConfig(data)
+}
 
+// parseBranchConfig parses the contents of config.yaml
+func parseBranchConfig(data []byte) (*BranchConfig, error) {
 	var config BranchConfig
 	if err := yaml.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("failed to parse config.yaml: %v", err)
@@ -222,19 +314,31 @@ func (gm *GitManager) GetBranchConfig(branchName string) (*BranchConfig, error)
 	return &config, nil
 }
 
-// GetBranchVersions reads versions.json from a specific branch
-func (gm *GitManager) GetBranchVersions(branchName string) (*VersionInfo, error) {
-	// First ensure we have the latest version of the branch
-	targetDir := filepath.Join("repos", "temp", branchName)
-	if err := gm.CloneOrPullBranch(branchName, targetDir); err != nil {
-		return nil, fmt.Errorf("failed to get branch: %v", err)
+// GetBranchPipeline returns the validated pipeline declared at a branch, tag
+// or commit, or the default pipeline if none is declared there
+func (gm *GitManager) GetBranchPipeline(ref string) ([]PipelineStep, error) {
+	config, err := gm.GetBranchConfig(ref)
+	if err != nil {
+		return nil, err
 	}
 
-	// Read versions.json
-	versionsPath := filepath.Join(targetDir, "versions.json")
-	data, err := ioutil.ReadFile(versionsPath)
+	return config.resolvedPipeline()
+}
+
+// resolvedPipeline returns the validated pipeline the configuration declares
+func (c *BranchConfig) resolvedPipeline() ([]PipelineStep, error) {
+	pipeline, err := resolvePipeline(c.Pipeline, c.Build)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pipeline in config.yaml: %v", err)
+	}
+	return pipeline, nil
+}
+
+// GetBranchVersions reads versions.json at a branch, tag or commit
+func (gm *GitManager) GetBranchVersions(ref string) (*VersionInfo, error) {
+	data, err := gm.ReadFile(ref, "versions.json")
 	if err != nil {
-		return nil, fmt.Errorf("failed to read versions.json: %v", err)
+		return nil, err
 	}
 
 	var versions VersionInfo
@@ -245,19 +349,11 @@ func (gm *GitManager) GetBranchVersions(branchName string) (*VersionInfo, error)
 	return &versions, nil
 }
 
-// GetBranchReleaseNotes reads release-notes.md from a specific branch
-func (gm *GitManager) GetBranchReleaseNotes(branchName string) (string, error) {
-	// First ensure we have the latest version of the branch
-	targetDir := filepath.Join("repos", "temp", branchName)
-	if err := gm.CloneOrPullBranch(branchName, targetDir); err != nil {
-		return "", fmt.Errorf("failed to get branch: %v", err)
-	}
-
-	// Read release-notes.md
-	notesPath := filepath.Join(targetDir, "release-notes.md")
-	data, err := ioutil.ReadFile(notesPath)
+// GetBranchReleaseNotes reads release-notes.md at a branch, tag or commit
+func (gm *GitManager) GetBranchReleaseNotes(ref string) (string, error) {
+	data, err := gm.ReadFile(ref, "release-notes.md")
 	if err != nil {
-		return "", fmt.Errorf("failed to read release-notes.md: %v", err)
+		return "", err
 	}
 
 	return string(data), nil
@@ -267,8 +363,11 @@ func (gm *GitManager) GetBranchReleaseNotes(branchName string) (string, error) {
 // Script Execution
 // =============================================================================
 
-// ExecuteBuildScript executes a script from the cloned repository
-func (gm *GitManager) ExecuteBuildScript(repoDir, scriptPath string, conn *websocket.Conn, logFunc func(*websocket.Conn, string, string)) error {
+// ExecuteBuildScript executes a script from the cloned repository with
+// extraEnv added to its environment. Cancelling ctx kills the script together
+// with all of its child processes. Every line the script writes is passed to
+// logFunc as it is produced.
+func (gm *GitManager) ExecuteBuildScript(ctx context.Context, repoDir, scriptPath string, extraEnv []string, logFunc func(LogMessage)) error {
 	fullScriptPath := filepath.Join(repoDir, scriptPath)
 	
 	// Check if script exists
@@ -276,24 +375,55 @@ func (gm *GitManager) ExecuteBuildScript(repoDir, scriptPath string, conn *webso
 		return fmt.Errorf("script not found: %s", scriptPath)
 	}
 
-	logFunc(conn, fmt.Sprintf("🔧 執行腳本: %s", scriptPath), "info")
+	logFunc(newLogMessage(fmt.Sprintf("🔧 執行腳本: %s", scriptPath), "info"))
 
 	// Make script executable
 	if err := os.Chmod(fullScriptPath, 0755); err != nil {
 		return fmt.Errorf("failed to make script executable: %v", err)
 	}
 
-	// Set up environment variables
-	env := os.Environ()
-	if gm.currentConfig.Token != "" {
-		env = append(env, "GITLAB_TOKEN="+gm.currentConfig.Token)
-		env = append(env, "GIT_TOKEN="+gm.currentConfig.Token)
+	if err := gm.runProcess(ctx, repoDir, extraEnv, logFunc, "bash", scriptPath); err != nil { // Relative to repoDir
+		if ctx.Err() != nil {
+			return fmt.Errorf("script %s cancelled", scriptPath)
+		}
+		return fmt.Errorf("script execution failed: %w", err)
 	}
 
-	// Execute script
-	cmd := exec.Command("bash", fullScriptPath)
-	cmd.Dir = repoDir
+	logFunc(newLogMessage(fmt.Sprintf("✅ 腳本執行完成: %s", scriptPath), "success"))
+	return nil
+}
+
+// ExecuteCommand runs a shell command in dir and streams its output via logFunc
+func (gm *GitManager) ExecuteCommand(ctx context.Context, dir, command string, extraEnv []string, logFunc func(LogMessage)) error {
+	logFunc(newLogMessage(fmt.Sprintf("🔧 執行命令: %s", command), "info"))
+
+	if err := gm.runProcess(ctx, dir, extraEnv, logFunc, "bash", "-c", command); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("command cancelled")
+		}
+		return fmt.Errorf("command execution failed: %w", err)
+	}
+
+	logFunc(newLogMessage("✅ 命令執行完成", "success"))
+	return nil
+}
+
+// runProcess runs a command in dir with the Git credentials and extraEnv in
+// its environment, streaming stdout and stderr line by line via logFunc
+func (gm *GitManager) runProcess(ctx context.Context, dir string, extraEnv []string, logFunc func(LogMessage), name string, args ...string) error {
+	// Set up environment variables
+	env := append(os.Environ(), gm.gitEnv()...)
+	env = append(env, extraEnv...)
+
+	// Execute in its own process group so cancellation reaches child processes
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
 	cmd.Env = env
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+	cmd.WaitDelay = 5 * time.Second
 
 	// Create pipes for real-time output
 	stdout, err := cmd.StdoutPipe()
@@ -308,60 +438,44 @@ func (gm *GitManager) ExecuteBuildScript(repoDir, scriptPath string, conn *webso
 
 	// Start the command
 	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start script: %v", err)
+		return fmt.Errorf("failed to start process: %v", err)
 	}
 
-	// Read output in goroutines
-	go gm.readOutput(stdout, conn, logFunc, "info")
-	go gm.readOutput(stderr, conn, logFunc, "error")
+	// Read output in goroutines; all output must be consumed before Wait
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		gm.readOutput(stdout, StreamStdout, logFunc, "info")
+	}()
+	go func() {
+		defer wg.Done()
+		gm.readOutput(stderr, StreamStderr, logFunc, "error")
+	}()
+	wg.Wait()
 
 	// Wait for command to complete
-	if err := cmd.Wait(); err != nil {
-		return fmt.Errorf("script execution failed: %v", err)
-	}
-
-	logFunc(conn, fmt.Sprintf("✅ 腳本執行完成: %s", scriptPath), "success")
-	return nil
+	return cmd.Wait()
 }
 
 // =============================================================================
 // Helper Functions
 // =============================================================================
 
-// createAuthenticatedURL creates a Git URL with authentication
-func (gm *GitManager) createAuthenticatedURL() string {
-	if gm.currentConfig.Token == "" {
-		return gm.currentConfig.URL
-	}
-
-	// Handle different Git URL formats
-	url := gm.currentConfig.URL
-	if strings.HasPrefix(url, "https://") {
-		// For HTTPS URLs, inject token
-		// https://github.com/user/repo.git -> https://token@github.com/user/repo.git
-		url = strings.Replace(url, "https://", fmt.Sprintf("https://%s@", gm.currentConfig.Token), 1)
-	}
-	
-	return url
-}
-
-// createBranchInfo creates branch information
-func (gm *GitManager) createBranchInfo(branchName, commitHash string) Branch {
-	return Branch{
+// createBranchInfo creates branch information from its last commit
+func (gm *GitManager) createBranchInfo(branchName, commitHash, committedAt, author, subject string) Branch {
+	branch := Branch{
 		Name:        branchName,
-		Date:        time.Now().Format("2006-01-02"), // Would be better to get actual commit date
-		Description: gm.generateDescription(branchName),
-		CommitHash:  commitHash[:8], // Short hash
+		Description: subject,
+		CommitHash:  shortHash(commitHash),
 		IsRelease:   gm.isReleaseBranch(branchName),
+		Author:      author,
 	}
-}
-
-// generateDescription generates a description for the branch
-func (gm *GitManager) generateDescription(branchName string) string {
-	if gm.isReleaseBranch(branchName) {
-		return fmt.Sprintf("Release branch %s", branchName)
+	if date, err := time.Parse(time.RFC3339, committedAt); err == nil {
+		branch.CommittedAt = date
+		branch.Date = date.Format("2006-01-02")
 	}
-	return fmt.Sprintf("Development branch %s", branchName)
+	return branch
 }
 
 // isReleaseBranch checks if a branch is a release branch
@@ -390,8 +504,18 @@ func (gm *GitManager) isReleaseBranch(branchName string) bool {
 	return false
 }
 
-// readOutput reads command output and sends to WebSocket
-func (gm *GitManager) readOutput(pipe interface{}, conn *websocket.Conn, logFunc func(*websocket.Conn, string, string), msgType string) {
-	// Implementation depends on the pipe type, for now just a placeholder
-	// In real implementation, you'd read from the pipe and send each line via logFunc
-}
\ No newline at end of file
+// readOutput reads command output line by line and forwards each line to logFunc
+func (gm *GitManager) readOutput(pipe io.Reader, stream string, logFunc func(LogMessage), msgType string) {
+	err := streamLines(pipe, func(line string) {
+		text := stripANSI(line)
+		if strings.TrimSpace(text) == "" {
+			return
+		}
+		msg := newLogMessage(text, ansiLogType(line, msgType))
+		msg.Stream = stream
+		logFunc(msg)
+	})
+	if err != nil {
+		log.Printf("Error reading script %s: %v", stream, err)
+	}
+}
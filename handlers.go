@@ -1,16 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 	"io"
 
 	"github.com/gorilla/mux"
-	"github.com/gorilla/websocket"
 )
 
 // =============================================================================
@@ -25,13 +28,47 @@ type BuildRequest struct {
 	BuildImages   bool   `json:"buildImages"`
 	PushHarbor    bool   `json:"pushHarbor"`
 	Deploy        bool   `json:"deploy"`
+	User          string `json:"user"`
+	// Steps names the pipeline steps to run. When empty, the booleans above
+	// select the steps of the matching types.
+	Steps []string `json:"steps,omitempty"`
+	// Environment is the deployment environment the deploy steps target
+	Environment string `json:"environment,omitempty"`
+	// RefType says what is built: the head of Branch (RefTypeBranch, the
+	// default), the tag named by Branch (RefTypeTag), or Commit on Branch
+	// (RefTypeCommit)
+	RefType string `json:"refType,omitempty"`
+	// Commit is the SHA the build is pinned to. It is resolved from the ref
+	// when the build is requested and used by every step of the build.
+	Commit string `json:"commit,omitempty"`
+}
+
+// WebSocket actions sent by the frontend
+const (
+	wsActionBuild       = "build"
+	wsActionStop        = "stop"
+	wsActionSubscribe   = "subscribe"
+	wsActionUnsubscribe = "unsubscribe"
+)
+
+// wsMessage represents an incoming WebSocket message. Messages without an
+// action are treated as build requests for backwards compatibility.
+type wsMessage struct {
+	Action  string `json:"action"`
+	BuildID string `json:"buildId"`
+	BuildRequest
 }
 
 // LogMessage represents a log message sent via WebSocket
 type LogMessage struct {
-	Timestamp string `json:"timestamp"`
-	Message   string `json:"message"`
-	Type      string `json:"type"` // info, success, error, warning
+	Timestamp string    `json:"timestamp"`
+	Time      time.Time `json:"time"`
+	Message   string    `json:"message"`
+	Type      string    `json:"type"`               // info, success, error, warning
+	Stream    string    `json:"stream,omitempty"`   // stdout or stderr for script output
+	Step      string    `json:"step,omitempty"`     // Pipeline step that produced the message
+	Platform  string    `json:"platform,omitempty"` // Matrix platform that produced the message
+	Attempt   int       `json:"attempt,omitempty"`  // Attempt number of a step with retries
 }
 
 // =============================================================================
@@ -57,6 +94,24 @@ func (bm *BuildManager) GetGitConfigs(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// FetchGitConfig fetches the repository mirror of a Git configuration now
+// instead of waiting for its fetch interval to pass
+func (bm *BuildManager) FetchGitConfig(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	gitManager, exists := bm.gitManagerFor(vars["gitConfig"])
+	if !exists {
+		http.Error(w, "Git configuration not found", http.StatusNotFound)
+		return
+	}
+
+	if err := gitManager.Fetch(); err != nil {
+		log.Printf("Error fetching %s: %v", vars["gitConfig"], err)
+		http.Error(w, "Failed to fetch repository", http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // GetBranches returns all available branches from specified Git repository
 func (bm *BuildManager) GetBranches(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -64,17 +119,14 @@ func (bm *BuildManager) GetBranches(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	gitConfigName := vars["gitConfig"]
 	
-	gitConfig, exists := bm.config.GitConfigs[gitConfigName]
+	gitManager, exists := bm.gitManagerFor(gitConfigName)
 	if !exists {
 		http.Error(w, "Git configuration not found", http.StatusNotFound)
 		return
 	}
 	
-	// Update git manager with selected config
-	bm.gitManager.UpdateConfig(gitConfig)
-	
 	// Fetch branches from Git repository
-	branches, err := bm.gitManager.GetAllBranches()
+	branches, err := gitManager.GetAllBranches()
 	if err != nil {
 		log.Printf("Error fetching branches from Git: %v", err)
 		http.Error(w, "Failed to fetch branches from Git repository", http.StatusInternalServerError)
@@ -87,6 +139,30 @@ func (bm *BuildManager) GetBranches(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetTags returns all tags from specified Git repository
+func (bm *BuildManager) GetTags(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	gitManager, exists := bm.gitManagerFor(vars["gitConfig"])
+	if !exists {
+		http.Error(w, "Git configuration not found", http.StatusNotFound)
+		return
+	}
+
+	tags, err := gitManager.GetAllTags()
+	if err != nil {
+		log.Printf("Error fetching tags from Git: %v", err)
+		http.Error(w, "Failed to fetch tags from Git repository", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(tags); err != nil {
+		log.Printf("Error encoding tags: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
 // GetConfig returns configuration for specified branch
 func (bm *BuildManager) GetConfig(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -95,17 +171,16 @@ func (bm *BuildManager) GetConfig(w http.ResponseWriter, r *http.Request) {
 	gitConfigName := vars["gitConfig"]
 	branchName := vars["branch"]
 	
-	gitConfig, exists := bm.config.GitConfigs[gitConfigName]
+	gitManager, exists := bm.gitManagerFor(gitConfigName)
 	if !exists {
 		http.Error(w, "Git configuration not found", http.StatusNotFound)
 		return
 	}
 	
-	bm.gitManager.UpdateConfig(gitConfig)
-	
-	config, err := bm.gitManager.GetBranchConfig(branchName)
+	ref := refParam(r, branchName)
+	config, err := gitManager.GetBranchConfig(ref)
 	if err != nil {
-		log.Printf("Error fetching config for branch %s: %v", branchName, err)
+		log.Printf("Error fetching config for branch %s at %s: %v", branchName, ref, err)
 		http.Error(w, "Failed to fetch branch configuration", http.StatusInternalServerError)
 		return
 	}
@@ -116,6 +191,15 @@ func (bm *BuildManager) GetConfig(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// refParam returns the ref to read a branch's files at: the ?ref= query
+// parameter (a commit SHA or tag) if given, otherwise the branch head
+func refParam(r *http.Request, branchName string) string {
+	if ref := r.URL.Query().Get("ref"); ref != "" {
+		return ref
+	}
+	return branchName
+}
+
 // GetVersions returns version information for specified branch
 func (bm *BuildManager) GetVersions(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -124,17 +208,16 @@ func (bm *BuildManager) GetVersions(w http.ResponseWriter, r *http.Request) {
 	gitConfigName := vars["gitConfig"]
 	branchName := vars["branch"]
 	
-	gitConfig, exists := bm.config.GitConfigs[gitConfigName]
+	gitManager, exists := bm.gitManagerFor(gitConfigName)
 	if !exists {
 		http.Error(w, "Git configuration not found", http.StatusNotFound)
 		return
 	}
 	
-	bm.gitManager.UpdateConfig(gitConfig)
-	
-	versions, err := bm.gitManager.GetBranchVersions(branchName)
+	ref := refParam(r, branchName)
+	versions, err := gitManager.GetBranchVersions(ref)
 	if err != nil {
-		log.Printf("Error fetching versions for branch %s: %v", branchName, err)
+		log.Printf("Error fetching versions for branch %s at %s: %v", branchName, ref, err)
 		http.Error(w, "Failed to fetch branch versions", http.StatusInternalServerError)
 		return
 	}
@@ -153,23 +236,23 @@ func (bm *BuildManager) GetReleaseNotes(w http.ResponseWriter, r *http.Request)
 	gitConfigName := vars["gitConfig"]
 	branchName := vars["branch"]
 	
-	gitConfig, exists := bm.config.GitConfigs[gitConfigName]
+	gitManager, exists := bm.gitManagerFor(gitConfigName)
 	if !exists {
 		http.Error(w, "Git configuration not found", http.StatusNotFound)
 		return
 	}
 	
-	bm.gitManager.UpdateConfig(gitConfig)
-	
-	notes, err := bm.gitManager.GetBranchReleaseNotes(branchName)
+	ref := refParam(r, branchName)
+	notes, err := gitManager.GetBranchReleaseNotes(ref)
 	if err != nil {
-		log.Printf("Error fetching release notes for branch %s: %v", branchName, err)
+		log.Printf("Error fetching release notes for branch %s at %s: %v", branchName, ref, err)
 		http.Error(w, "Failed to fetch release notes", http.StatusInternalServerError)
 		return
 	}
 	
 	response := map[string]string{
 		"branch": branchName,
+		"ref":    ref,
 		"notes":  notes,
 	}
 	
@@ -179,6 +262,34 @@ func (bm *BuildManager) GetReleaseNotes(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// GetPipeline returns the effective pipeline of a branch, or of the commit or
+// tag given as ?ref=
+func (bm *BuildManager) GetPipeline(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	gitConfigName := vars["gitConfig"]
+	branchName := vars["branch"]
+
+	gitManager, exists := bm.gitManagerFor(gitConfigName)
+	if !exists {
+		http.Error(w, "Git configuration not found", http.StatusNotFound)
+		return
+	}
+
+	pipeline, err := gitManager.GetBranchPipeline(refParam(r, branchName))
+	if err != nil {
+		log.Printf("Error fetching pipeline for branch %s: %v", branchName, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(pipeline); err != nil {
+		log.Printf("Error encoding pipeline: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
 // ServeUI serves the UI template from embedded files
 func (bm *BuildManager) ServeUI(w http.ResponseWriter, r *http.Request) {
     w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -203,6 +314,285 @@ func (bm *BuildManager) ServeUI(w http.ResponseWriter, r *http.Request) {
     }
 }
 
+// =============================================================================
+// Build API Handlers
+// =============================================================================
+
+// StartBuild creates a new build from a JSON BuildRequest and starts it
+func (bm *BuildManager) StartBuild(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req BuildRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid build request", http.StatusBadRequest)
+		return
+	}
+
+	build, err := bm.startBuild(req, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(build.Snapshot()); err != nil {
+		log.Printf("Error encoding build: %v", err)
+	}
+}
+
+// RerunBuild starts a new build repeating a finished build from the step
+// given as {"from_step": "...", "user": "..."}
+func (bm *BuildManager) RerunBuild(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var body struct {
+		FromStep string `json:"from_step"`
+		User     string `json:"user"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.FromStep == "" {
+		http.Error(w, "Invalid re-run request", http.StatusBadRequest)
+		return
+	}
+
+	vars := mux.Vars(r)
+	build, err := bm.rerunBuild(vars["id"], body.FromStep, body.User, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(build.Snapshot()); err != nil {
+		log.Printf("Error encoding build: %v", err)
+	}
+}
+
+// PromoteBuild redeploys the version of a deployed build to another
+// environment, given as {"environment": "...", "user": "..."}. Without an
+// environment the build is promoted to the next one in deployment.environments.
+func (bm *BuildManager) PromoteBuild(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var body struct {
+		Environment string `json:"environment"`
+		User        string `json:"user"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		http.Error(w, "Invalid promote request", http.StatusBadRequest)
+		return
+	}
+
+	vars := mux.Vars(r)
+	build, err := bm.promoteBuild(vars["id"], body.Environment, body.User, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(build.Snapshot()); err != nil {
+		log.Printf("Error encoding build: %v", err)
+	}
+}
+
+// ApproveBuild approves a release build waiting at its approval gate,
+// given as {"user": "...", "comment": "..."}
+func (bm *BuildManager) ApproveBuild(w http.ResponseWriter, r *http.Request) {
+	bm.handleApprovalDecision(w, r, true)
+}
+
+// RejectBuild rejects a release build waiting at its approval gate,
+// given as {"user": "...", "comment": "..."}
+func (bm *BuildManager) RejectBuild(w http.ResponseWriter, r *http.Request) {
+	bm.handleApprovalDecision(w, r, false)
+}
+
+// handleApprovalDecision submits an approval decision and returns the build
+func (bm *BuildManager) handleApprovalDecision(w http.ResponseWriter, r *http.Request, approved bool) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var body struct {
+		User    string `json:"user"`
+		Comment string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid approval request", http.StatusBadRequest)
+		return
+	}
+
+	vars := mux.Vars(r)
+	decision := approvalDecision{approved: approved, user: body.User, comment: body.Comment}
+	if err := bm.decideApproval(vars["id"], decision); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Build %s approval decided by %q (approved: %v)", vars["id"], body.User, approved)
+	record, _ := bm.lookupBuild(vars["id"])
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(record); err != nil {
+		log.Printf("Error encoding build: %v", err)
+	}
+}
+
+// GetBuildStatus returns the current state of a build
+func (bm *BuildManager) GetBuildStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	record, exists := bm.lookupBuild(vars["id"])
+	if !exists {
+		http.Error(w, "Build not found", http.StatusNotFound)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(record); err != nil {
+		log.Printf("Error encoding build status: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// GetBuildHistory returns recorded builds matching the query filters
+// (gitConfig, branch, status, user, from, to, limit)
+func (bm *BuildManager) GetBuildHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	filter := HistoryFilter{
+		GitConfig: query.Get("gitConfig"),
+		Branch:    query.Get("branch"),
+		Status:    BuildStatus(query.Get("status")),
+		User:      query.Get("user"),
+		Limit:     100,
+	}
+
+	var err error
+	if filter.From, err = parseHistoryTime(query.Get("from"), false); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = parseHistoryTime(query.Get("to"), true); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(bm.history.Query(filter)); err != nil {
+		log.Printf("Error encoding build history: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// GetBuildLogs returns every log message recorded for a build
+func (bm *BuildManager) GetBuildLogs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	logs, err := bm.buildLogs(vars["id"])
+	if err != nil {
+		http.Error(w, "Build log not found", http.StatusNotFound)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(logs); err != nil {
+		log.Printf("Error encoding build logs: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// DownloadBuildLog returns the full build log as plain text
+func (bm *BuildManager) DownloadBuildLog(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	logs, err := bm.buildLogs(vars["id"])
+	if err != nil {
+		http.Error(w, "Build log not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"build-%s.log\"", vars["id"]))
+	for _, msg := range logs {
+		if _, err := io.WriteString(w, formatLogLine(msg)); err != nil {
+			log.Printf("Error writing build log: %v", err)
+			return
+		}
+	}
+}
+
+// CancelBuild stops a pending or running build
+func (bm *BuildManager) CancelBuild(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	if _, exists := bm.lookupBuild(vars["id"]); !exists {
+		http.Error(w, "Build not found", http.StatusNotFound)
+		return
+	}
+
+	if err := bm.cancelBuild(vars["id"]); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	record, _ := bm.lookupBuild(vars["id"])
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(record); err != nil {
+		log.Printf("Error encoding build: %v", err)
+	}
+}
+
+// GetQueue returns the running and queued builds
+func (bm *BuildManager) GetQueue(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(bm.queue.Status()); err != nil {
+		log.Printf("Error encoding queue: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// MoveQueuedBuild moves a queued build to a new (1-based) position
+func (bm *BuildManager) MoveQueuedBuild(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var body struct {
+		Position int `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid move request", http.StatusBadRequest)
+		return
+	}
+
+	vars := mux.Vars(r)
+	if err := bm.queue.Move(vars["id"], body.Position); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(bm.queue.Status()); err != nil {
+		log.Printf("Error encoding queue: %v", err)
+	}
+}
+
+// RemoveQueuedBuild drops a build from the queue before it starts
+func (bm *BuildManager) RemoveQueuedBuild(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	run, queued := bm.queue.Remove(vars["id"])
+	if !queued {
+		http.Error(w, "Build is not queued", http.StatusNotFound)
+		return
+	}
+
+	run.build.Cancel()
+	bm.finishCancelledBuild(run)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // =============================================================================
 // WebSocket Handler
 // =============================================================================
@@ -216,197 +606,627 @@ func (bm *BuildManager) HandleWebSocket(w http.ResponseWriter, r *http.Request)
 	}
 	defer conn.Close()
 
+	client := newWSClient(conn)
+	defer client.close()
+	defer bm.events.UnsubscribeAll(client)
+
 	log.Println("WebSocket client connected")
 
 	// Send initial connection message
-	bm.sendLogMessage(conn, "WebSocket 連接已建立", "info")
+	client.sendLogMessage("WebSocket 連接已建立", "info")
 
 	// Keep connection alive and handle incoming messages
 	for {
-		var buildReq BuildRequest
-		err := conn.ReadJSON(&buildReq)
+		var msg wsMessage
+		err := conn.ReadJSON(&msg)
 		if err != nil {
 			log.Printf("WebSocket read error: %v", err)
 			break
 		}
 
-		// Handle build request
-		go bm.handleBuildRequest(conn, buildReq)
+		switch msg.Action {
+		case wsActionStop:
+			if err := bm.cancelBuild(msg.BuildID); err != nil {
+				client.sendLogMessage(fmt.Sprintf("❌ 無法停止構建: %v", err), "error")
+				continue
+			}
+			client.sendLogMessage(fmt.Sprintf("🛑 正在停止構建 %s...", msg.BuildID), "warning")
+
+		case wsActionSubscribe:
+			if err := bm.subscribeBuild(msg.BuildID, client); err != nil {
+				client.sendLogMessage(fmt.Sprintf("❌ 無法訂閱構建: %v", err), "error")
+			}
+
+		case wsActionUnsubscribe:
+			bm.events.Unsubscribe(msg.BuildID, client)
+
+		case "", wsActionBuild:
+			// Handle build request
+			if _, err := bm.startBuild(msg.BuildRequest, client); err != nil {
+				client.sendLogMessage(fmt.Sprintf("❌ 無法開始構建: %v", err), "error")
+			}
+
+		default:
+			client.sendLogMessage(fmt.Sprintf("未知的操作: %s", msg.Action), "warning")
+		}
 	}
 }
 
+// subscribeBuild sends the current state and backlog of a build to sub and,
+// while the build is active, keeps delivering its live events
+func (bm *BuildManager) subscribeBuild(id string, sub EventSubscriber) error {
+	record, exists := bm.lookupBuild(id)
+	if !exists {
+		return fmt.Errorf("build %s not found", id)
+	}
+
+	sub.SendEvent(BuildEvent{Type: EventBuild, BuildID: id, Data: record})
+	if bm.events.Subscribe(id, sub) {
+		return nil
+	}
+
+	// Finished build: replay the archived log and final status
+	if logs, err := bm.buildLogs(id); err == nil && len(logs) > 0 {
+		sub.SendEvent(BuildEvent{Type: EventLogBatch, BuildID: id, Data: logs})
+	}
+	record, _ = bm.lookupBuild(id)
+	sub.SendEvent(BuildEvent{Type: EventProgress, BuildID: id, Data: map[string]int{"progress": record.Progress}})
+	sub.SendEvent(BuildEvent{Type: EventStatus, BuildID: id, Data: statusData(record)})
+	return nil
+}
+
 // =============================================================================
 // Build Process Handler
 // =============================================================================
 
-// handleBuildRequest processes a build request and sends real-time updates
-func (bm *BuildManager) handleBuildRequest(conn *websocket.Conn, req BuildRequest) {
-	bm.sendLogMessage(conn, fmt.Sprintf("🚀 開始構建分支 %s (Git: %s)", req.Branch, req.GitConfig), "info")
+// buildRun carries the state of a build while it is executing
+type buildRun struct {
+	bm       *BuildManager
+	build    *Build
+	req      BuildRequest
+	git      *GitManager    // Captured at start so the build is unaffected by other requests
+	steps    []PipelineStep // Selected steps in execution order
+	archive  *LogWriter
 
-	// Update git manager with selected config
-	gitConfig := bm.config.GitConfigs[req.GitConfig]
-	bm.gitManager.UpdateConfig(gitConfig)
+	emitMu sync.Mutex
 
-	progress := 0
-	stepSize := 100 / bm.countSteps(req)
+	workspaceMu sync.Mutex
+	workspace   string // Isolated checkout at the build's commit, created on first use
 
-	// Execute build steps
-	if req.PullRepos {
-		if !bm.executePullRepos(conn, &progress, stepSize, req.GitConfig, req.Branch) {
-			return
+	approvalOnce sync.Once
+	approvalErr  error // Outcome of the approval gate, shared by every gated step
+}
+
+// log records a log message and publishes it to the build subscribers
+func (run *buildRun) log(message, msgType string) {
+	run.emit(newLogMessage(message, msgType))
+}
+
+// emit archives a prepared log message and publishes it to the build subscribers
+func (run *buildRun) emit(msg LogMessage) {
+	run.emitMu.Lock()
+	defer run.emitMu.Unlock()
+
+	if run.archive != nil {
+		if err := run.archive.Write(msg); err != nil {
+			log.Printf("Error archiving log for build %s: %v", run.build.ID(), err)
 		}
 	}
+	run.publish(EventLog, msg)
+}
 
-	if req.BuildImages {
-		if !bm.executeBuildImages(conn, &progress, stepSize, req.GitConfig, req.Branch) {
-			return
-		}
+// publish sends an event about this build to all of its subscribers
+func (run *buildRun) publish(eventType string, data interface{}) {
+	run.bm.events.Publish(BuildEvent{Type: eventType, BuildID: run.build.ID(), Data: data})
+}
+
+// stepLogger returns a log function that tags messages with the step name,
+// so output of steps running in parallel can be told apart
+func (run *buildRun) stepLogger(step PipelineStep) func(LogMessage) {
+	return func(msg LogMessage) {
+		msg.Step = step.Name
+		run.emit(msg)
 	}
+}
 
-	if req.PushHarbor {
-		if !bm.executePushHarbor(conn, &progress, stepSize, req.GitConfig, req.Branch) {
-			return
-		}
+// logStep records a log message produced by a step
+func (run *buildRun) logStep(step PipelineStep, message, msgType string) {
+	run.stepLogger(step)(newLogMessage(message, msgType))
+}
+
+// platformLogger returns a log function that tags messages with the step and platform
+func (run *buildRun) platformLogger(step PipelineStep, platform string) func(LogMessage) {
+	return func(msg LogMessage) {
+		msg.Step = step.Name
+		msg.Platform = platform
+		run.emit(msg)
 	}
+}
 
-	if req.Deploy {
-		if !bm.executeDeploy(conn, &progress, req.GitConfig, req.Branch) {
-			return
+// startStep marks a step as running and broadcasts its state
+func (run *buildRun) startStep(name string) {
+	run.build.startStep(name)
+	run.publishStep(name)
+}
+
+// finishStep records the outcome of a step and broadcasts its state
+func (run *buildRun) finishStep(name string, status BuildStatus, errMsg string) {
+	run.build.finishStep(name, status, errMsg)
+	run.publishStep(name)
+}
+
+// skipPendingSteps marks the steps that never started as skipped and broadcasts them
+func (run *buildRun) skipPendingSteps() {
+	run.build.skipPendingSteps()
+	for _, step := range run.build.Snapshot().Steps {
+		if step.Status == BuildStatusSkipped {
+			run.publish(EventStep, step)
 		}
 	}
+}
 
-	bm.sendLogMessage(conn, "🎉 構建完成！", "success")
+// startPlatform marks one platform of a matrix step as running and broadcasts the step
+func (run *buildRun) startPlatform(name, platform string) {
+	run.build.startPlatform(name, platform)
+	run.publishStep(name)
 }
 
-// =============================================================================
-// Build Step Implementations
-// =============================================================================
+// setApproval records the state of the approval gate and broadcasts it with
+// the build status
+func (run *buildRun) setApproval(approval ApprovalRecord) {
+	run.build.setApproval(approval)
+	run.persist()
+	run.publish(EventStatus, statusData(run.build.Snapshot()))
+}
 
-// executePullRepos executes the pull repositories step
-func (bm *BuildManager) executePullRepos(conn *websocket.Conn, progress *int, stepSize int, gitConfig, branchName string) bool {
-	bm.sendLogMessage(conn, "▶️ 拉取配置倉庫...", "info")
-	bm.sendProgress(conn, *progress)
+// setStepAttempt records the attempt a step is on and broadcasts its state
+func (run *buildRun) setStepAttempt(name string, attempt int) {
+	run.build.setStepAttempt(name, attempt)
+	run.publishStep(name)
+}
 
-	// Create target directory
-	targetDir := filepath.Join("repos", gitConfig, branchName)
-	
-	// Clone or pull the branch
-	if err := bm.gitManager.CloneOrPullBranch(branchName, targetDir); err != nil {
-		bm.sendLogMessage(conn, fmt.Sprintf("❌ 拉取失敗: %v", err), "error")
-		return false
+// setPlatformAttempt records the attempt a platform is on and broadcasts the step
+func (run *buildRun) setPlatformAttempt(name, platform string, attempt int) {
+	run.build.setPlatformAttempt(name, platform, attempt)
+	run.publishStep(name)
+}
+
+// setRollback records the rollback of a deploy step and broadcasts the step
+func (run *buildRun) setRollback(name string, rollback RollbackResult) {
+	run.build.setRollback(name, rollback)
+	run.publishStep(name)
+}
+
+// finishPlatform records the outcome of one platform and broadcasts the step
+func (run *buildRun) finishPlatform(name, platform string, status BuildStatus, errMsg string) {
+	run.build.finishPlatform(name, platform, status, errMsg)
+	run.publishStep(name)
+}
+
+// publishStep broadcasts the current state of a step
+func (run *buildRun) publishStep(name string) {
+	record := run.build.Snapshot()
+	if step := record.step(name); step != nil {
+		run.publish(EventStep, *step)
 	}
+}
 
-	bm.sendLogMessage(conn, "✅ 拉取配置倉庫完成", "success")
-	*progress += stepSize
-	bm.sendProgress(conn, *progress)
-	return true
+// setProgress records and broadcasts the build progress
+func (run *buildRun) setProgress(progress int) {
+	run.build.setProgress(progress)
+	run.publish(EventProgress, map[string]int{"progress": progress})
 }
 
-// executeBuildImages executes the build images step
-func (bm *BuildManager) executeBuildImages(conn *websocket.Conn, progress *int, stepSize int, gitConfig, branchName string) bool {
-	bm.sendLogMessage(conn, "▶️ 執行構建腳本...", "info")
-	bm.sendProgress(conn, *progress)
+// workspaceKey identifies the workspace the build uses; only one build may use it at a time
+func (run *buildRun) workspaceKey() string {
+	return run.req.GitConfig + "/" + run.req.Branch
+}
 
-	// Execute build script from the cloned repository
-	targetDir := filepath.Join("repos", gitConfig, branchName)
-	if err := bm.gitManager.ExecuteBuildScript(targetDir, "scripts/build.sh", conn, bm.sendLogMessage); err != nil {
-		bm.sendLogMessage(conn, fmt.Sprintf("❌ 構建失敗: %v", err), "error")
-		return false
+// setQueuePosition records and broadcasts the position of a queued build
+func (run *buildRun) setQueuePosition(position int) {
+	if !run.build.setQueuePosition(position) {
+		return
 	}
+	run.publish(EventQueue, map[string]interface{}{
+		"build_id": run.build.ID(),
+		"position": position,
+	})
+}
+
+// setStatus updates the build status, stores it in the history and notifies the client
+func (run *buildRun) setStatus(status BuildStatus, errMsg string) {
+	run.build.setStatus(status, errMsg)
+	persisted := run.persist()
+	run.publish(EventStatus, statusData(run.build.Snapshot()))
 
-	bm.sendLogMessage(conn, "✅ 構建腳本執行完成", "success")
-	*progress += stepSize
-	bm.sendProgress(conn, *progress)
+	if !status.IsFinal() {
+		return
+	}
+	if run.archive != nil {
+		if err := run.archive.Close(); err != nil {
+			log.Printf("Error closing log archive for build %s: %v", run.build.ID(), err)
+		}
+	}
+	run.bm.events.Close(run.build.ID())
+
+	// From here on the build is served from the history store; if saving
+	// failed it stays in memory so its final state is not lost
+	if persisted {
+		run.bm.builds.Remove(run.build.ID())
+	}
+}
+
+// persist saves the current build state to the history store and reports
+// whether it succeeded
+func (run *buildRun) persist() bool {
+	if err := run.bm.history.Save(run.build.Snapshot()); err != nil {
+		log.Printf("Error saving build %s to history: %v", run.build.ID(), err)
+		return false
+	}
 	return true
 }
 
-// executePushHarbor executes the push to Harbor step
-func (bm *BuildManager) executePushHarbor(conn *websocket.Conn, progress *int, stepSize int, gitConfig, branchName string) bool {
-	bm.sendLogMessage(conn, "▶️ 推送到 Harbor...", "info")
-	bm.sendProgress(conn, *progress)
+// startBuild validates a build request, registers the build and runs it in the
+// background. If sub is non-nil it is subscribed to the build's events.
+func (bm *BuildManager) startBuild(req BuildRequest, sub EventSubscriber) (*Build, error) {
+	gitManager, exists := bm.gitManagerFor(req.GitConfig)
+	if !exists {
+		return nil, fmt.Errorf("git configuration %q not found", req.GitConfig)
+	}
+	if req.Branch == "" {
+		return nil, fmt.Errorf("branch is required")
+	}
+	// The approval gate keeps requesters from approving their own release,
+	// which needs to know who the requester is
+	req.User = strings.TrimSpace(req.User)
+	if req.User == "" && gitManager.isReleaseBranch(req.Branch) {
+		return nil, fmt.Errorf("user is required to build release branch %s", req.Branch)
+	}
+	if err := gitManager.pinSource(&req); err != nil {
+		return nil, err
+	}
 
-	// Execute push script from the cloned repository (if exists)
-	targetDir := filepath.Join("repos", gitConfig, branchName)
-	if err := bm.gitManager.ExecuteBuildScript(targetDir, "scripts/push.sh", conn, bm.sendLogMessage); err != nil {
-		bm.sendLogMessage(conn, fmt.Sprintf("⚠️ 推送腳本執行警告: %v", err), "warning")
-		// Continue even if push script fails or doesn't exist
+	config, err := gitManager.GetBranchConfig(req.Commit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pipeline: %v", err)
+	}
+	pipeline, err := config.resolvedPipeline()
+	if err != nil {
+		return nil, err
+	}
+	steps, err := selectSteps(pipeline, req)
+	if err != nil {
+		return nil, err
+	}
+	if req.Environment != "" || hasDeploySteps(steps) {
+		if req.Environment, err = config.Deployment.resolveEnvironment(req.Environment); err != nil {
+			return nil, err
+		}
 	}
 
-	bm.sendLogMessage(conn, "✅ 推送步驟完成", "success")
-	*progress += stepSize
-	bm.sendProgress(conn, *progress)
-	return true
+	build := NewBuild(req, steps)
+	bm.launchBuild(build, gitManager, steps, sub)
+	return build, nil
 }
 
-// executeDeploy executes the deployment step
-func (bm *BuildManager) executeDeploy(conn *websocket.Conn, progress *int, gitConfig, branchName string) bool {
-	bm.sendLogMessage(conn, "▶️ 執行部署...", "info")
-	bm.sendProgress(conn, *progress)
+// rerunBuild starts a new build that repeats a finished build from one of its
+// steps. The re-run uses the parent's request, pipeline and commit, and works
+// in the parent's workspace when it has been retained.
+func (bm *BuildManager) rerunBuild(parentID, fromStep, user string, sub EventSubscriber) (*Build, error) {
+	parent, exists := bm.lookupBuild(parentID)
+	if !exists {
+		return nil, fmt.Errorf("build %s not found", parentID)
+	}
+	if !parent.Status.IsFinal() {
+		return nil, fmt.Errorf("build %s has not finished yet", parentID)
+	}
+	if parent.CommitHash == "" {
+		return nil, fmt.Errorf("build %s has no recorded commit to re-run", parentID)
+	}
 
-	// Execute deploy script from the cloned repository (if exists)
-	targetDir := filepath.Join("repos", gitConfig, branchName)
-	if err := bm.gitManager.ExecuteBuildScript(targetDir, "scripts/deploy.sh", conn, bm.sendLogMessage); err != nil {
-		bm.sendLogMessage(conn, fmt.Sprintf("⚠️ 部署腳本執行警告: %v", err), "warning")
-		// Continue even if deploy script fails or doesn't exist
+	req := parent.Request
+	gitManager, exists := bm.gitManagerFor(req.GitConfig)
+	if !exists {
+		return nil, fmt.Errorf("git configuration %q not found", req.GitConfig)
+	}
+	steps, err := rerunSteps(parent, fromStep)
+	if err != nil {
+		return nil, err
+	}
+	if user != "" {
+		req.User = user
 	}
 
-	bm.sendLogMessage(conn, "✅ 部署步驟完成", "success")
-	*progress = 100
-	bm.sendProgress(conn, *progress)
-	return true
+	build := NewBuild(req, steps)
+	build.setParent(parent, fromStep)
+	reuseWorkspace(build, parent)
+	bm.launchBuild(build, gitManager, steps, sub)
+	return build, nil
+}
+
+// launchBuild registers a new build and queues it for execution
+func (bm *BuildManager) launchBuild(build *Build, gitManager *GitManager, steps []PipelineStep, sub EventSubscriber) {
+	record := build.Snapshot()
+	req := record.Request
+	bm.builds.Add(build)
+	log.Printf("Build %s created for %s/%s by %q", build.ID(), req.GitConfig, req.Branch, req.User)
+
+	run := &buildRun{
+		bm:        bm,
+		build:     build,
+		req:       req,
+		git:       gitManager,
+		steps:     steps,
+		workspace: record.Workspace,
+	}
+	if archive, err := bm.logs.Create(build.ID()); err != nil {
+		log.Printf("Error creating log archive for build %s: %v", build.ID(), err)
+	} else {
+		run.archive = archive
+	}
+	run.persist()
+
+	bm.events.Open(build.ID())
+	if sub != nil {
+		bm.events.Subscribe(build.ID(), sub)
+		sub.SendEvent(BuildEvent{Type: EventBuild, BuildID: build.ID(), Data: build.Snapshot()})
+	}
+
+	run.setStatus(BuildStatusQueued, "")
+	bm.queue.Enqueue(run)
+}
+
+// handleBuildRequest processes a build request and sends real-time updates
+func (bm *BuildManager) handleBuildRequest(run *buildRun) {
+	defer bm.queue.Done(run)
+	defer bm.releaseWorkspace(run)
+
+	req := run.req
+	run.setStatus(BuildStatusRunning, "")
+	run.log(fmt.Sprintf("🚀 開始構建%s (Git: %s)", req.sourceLabel(), req.GitConfig), "info")
+	if record := run.build.Snapshot(); record.ParentID != "" {
+		if record.PromotedFrom != "" {
+			run.log(fmt.Sprintf("🚚 將構建 %s 的版本 %s 從 %s 推進到 %s (commit %s)", record.ParentID, record.Version, record.PromotedFrom, req.Environment, shortHash(record.CommitHash)), "info")
+		} else {
+			run.log(fmt.Sprintf("🔁 從構建 %s 的步驟 %s 重新執行 (commit %s)", record.ParentID, record.RerunFrom, shortHash(record.CommitHash)), "info")
+		}
+		if run.workspace != "" {
+			run.log(fmt.Sprintf("📂 沿用構建工作區 %s", run.workspace), "info")
+		}
+	}
+
+	if timeout := bm.buildTimeout(); timeout > 0 {
+		deadline := time.AfterFunc(timeout, run.build.Expire)
+		defer deadline.Stop()
+	}
+
+	// Execute pipeline steps as a dependency graph
+	failed := bm.runPipeline(run)
+	if run.build.Context().Err() != nil {
+		bm.finishCancelledBuild(run)
+		return
+	}
+	if failed != "" {
+		run.skipPendingSteps()
+		record := run.build.Snapshot()
+		if step := record.step(failed); step != nil && step.Status == BuildStatusTimedOut {
+			run.setStatus(BuildStatusTimedOut, fmt.Sprintf("step %s timed out", failed))
+			return
+		}
+		run.setStatus(BuildStatusFailed, fmt.Sprintf("step %s failed", failed))
+		return
+	}
+
+	run.setProgress(100)
+	run.log("🎉 構建完成！", "success")
+	run.setStatus(BuildStatusCompleted, "")
+}
+
+// finishCancelledBuild marks the remaining steps as skipped and the build as
+// cancelled, or as timed out if it was stopped by its deadline
+func (bm *BuildManager) finishCancelledBuild(run *buildRun) {
+	run.skipPendingSteps()
+	if run.build.Expired() {
+		run.log(fmt.Sprintf("⏰ 構建超過時限 %s，略過剩餘步驟", bm.buildTimeout()), "error")
+		run.setStatus(BuildStatusTimedOut, fmt.Sprintf("build exceeded its deadline of %s", bm.buildTimeout()))
+		return
+	}
+	run.log("🛑 構建已取消，略過剩餘步驟", "warning")
+	run.setStatus(BuildStatusCancelled, "")
+}
+
+// cancelBuild requests cancellation of a build by ID
+func (bm *BuildManager) cancelBuild(id string) error {
+	// Queued builds are simply dropped from the queue
+	if run, queued := bm.queue.Remove(id); queued {
+		run.build.Cancel()
+		bm.finishCancelledBuild(run)
+		log.Printf("Build %s removed from queue", id)
+		return nil
+	}
+
+	build, exists := bm.builds.Get(id)
+	if !exists {
+		if _, finished := bm.history.Get(id); finished {
+			return fmt.Errorf("build %s has already finished", id)
+		}
+		return fmt.Errorf("build %s not found", id)
+	}
+	if err := build.Cancel(); err != nil {
+		return err
+	}
+	log.Printf("Build %s cancellation requested", id)
+	return nil
 }
 
 // =============================================================================
-// WebSocket Communication Helpers
+// Build Step Implementations
 // =============================================================================
 
-// sendLogMessage sends a log message via WebSocket
-func (bm *BuildManager) sendLogMessage(conn *websocket.Conn, message, msgType string) {
-	logMsg := LogMessage{
-		Timestamp: time.Now().Format("15:04:05"),
-		Message:   message,
-		Type:      msgType,
+// executeStep runs a single pipeline step
+func (bm *BuildManager) executeStep(run *buildRun, step PipelineStep) error {
+	if step.Type == StepTypePull {
+		return bm.executePull(run, step)
 	}
 
-	if err := conn.WriteJSON(map[string]interface{}{
-		"type": "log",
-		"data": logMsg,
-	}); err != nil {
-		log.Printf("WebSocket write error: %v", err)
+	if err := bm.awaitApproval(run, step); err != nil {
+		return err
 	}
+
+	run.logStep(step, fmt.Sprintf("▶️ 執行步驟 %s...", stepLabel(step)), "info")
+	workspace, err := bm.workspaceFor(run)
+	if err != nil {
+		return fmt.Errorf("failed to prepare workspace: %v", err)
+	}
+
+	if len(step.Platforms) > 0 {
+		err = bm.executeMatrix(run, step, workspace)
+	} else {
+		err = bm.runStepWithRetry(run.build.Context(), run, step, workspace, run.stepEnv(step), run.stepLogger(step), func(attempt int) {
+			run.setStepAttempt(step.Name, attempt)
+		})
+	}
+	if err == nil && step.Type == StepTypeDeploy {
+		err = bm.checkDeployment(run, step, workspace)
+	}
+	if err != nil {
+		if step.Rollback != nil && run.build.Context().Err() == nil {
+			bm.executeRollback(run, step, workspace)
+		}
+		return err
+	}
+
+	run.logStep(step, fmt.Sprintf("✅ 步驟 %s 完成", stepLabel(step)), "success")
+	return nil
+}
+
+// stepTimeoutError reports a step that exceeded its timeout
+type stepTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *stepTimeoutError) Error() string {
+	return fmt.Sprintf("step timed out after %s", e.timeout)
+}
+
+// failureStatus returns the status recorded for a step that failed with err
+func failureStatus(err error) BuildStatus {
+	var timeoutErr *stepTimeoutError
+	if errors.As(err, &timeoutErr) {
+		return BuildStatusTimedOut
+	}
+	return BuildStatusFailed
+}
+
+// runStepProcess runs the script or command of a step in the workspace. The
+// step's own timeout, or else the configured default, kills the process tree
+// when exceeded.
+func (bm *BuildManager) runStepProcess(ctx context.Context, run *buildRun, step PipelineStep, workspace string, env []string, logFunc func(LogMessage)) error {
+	timeout := bm.stepTimeout(step)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var err error
+	if step.Script != "" {
+		err = run.git.ExecuteBuildScript(ctx, workspace, step.Script, env, logFunc)
+	} else {
+		err = run.git.ExecuteCommand(ctx, workspace, step.Command, env, logFunc)
+	}
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return &stepTimeoutError{timeout: timeout}
+	}
+	return err
+}
+
+// stepTimeout returns the step's own timeout, or else the configured default,
+// 0 meaning none
+func (bm *BuildManager) stepTimeout(step PipelineStep) time.Duration {
+	if step.Timeout > 0 {
+		return time.Duration(step.Timeout) * time.Second
+	}
+	return time.Duration(bm.config.Build.StepTimeoutSeconds) * time.Second
 }
 
-// sendProgress sends progress update via WebSocket
-func (bm *BuildManager) sendProgress(conn *websocket.Conn, progress int) {
-	if err := conn.WriteJSON(map[string]interface{}{
-		"type": "progress",
-		"data": map[string]int{"progress": progress},
-	}); err != nil {
-		log.Printf("WebSocket write error: %v", err)
+// executePull fetches the repository mirror. The build keeps the commit it
+// was pinned to when requested, even if the branch has moved since. The fetch
+// is bound to the build and the step timeout like any other step process.
+func (bm *BuildManager) executePull(run *buildRun, step PipelineStep) error {
+	run.logStep(step, "▶️ 拉取配置倉庫...", "info")
+
+	ctx := run.build.Context()
+	timeout := bm.stepTimeout(step)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := run.git.FetchContext(ctx); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return &stepTimeoutError{timeout: timeout}
+		}
+		return err
+	}
+
+	commitHash := run.build.Snapshot().CommitHash
+	run.logStep(step, fmt.Sprintf("📌 Commit: %s", commitHash), "info")
+	if run.req.RefType == RefTypeBranch {
+		if head, err := run.git.ResolveBranch(run.req.Branch); err == nil && head != commitHash {
+			run.logStep(step, fmt.Sprintf("ℹ️ 分支 %s 已更新至 %s，本次構建仍使用 %s", run.req.Branch, shortHash(head), shortHash(commitHash)), "info")
+		}
 	}
+
+	run.logStep(step, "✅ 拉取配置倉庫完成", "success")
+	return nil
 }
 
 // =============================================================================
 // Utility Functions
 // =============================================================================
 
-// countSteps counts the number of enabled build steps
-func (bm *BuildManager) countSteps(req BuildRequest) int {
-	count := 0
-	if req.PullRepos {
-		count++
-	}
-	if req.BuildImages {
-		count++
+// buildLogs returns the logs of a live build, falling back to the log archive
+func (bm *BuildManager) buildLogs(id string) ([]LogMessage, error) {
+	if logs, ok := bm.events.Logs(id); ok {
+		return logs, nil
 	}
-	if req.PushHarbor {
-		count++
+	return bm.logs.Read(id)
+}
+
+// statusData builds the payload of a status event
+func statusData(record BuildRecord) map[string]interface{} {
+	return map[string]interface{}{
+		"build_id": record.ID,
+		"status":   record.Status,
+		"error":    record.Error,
+		"approval": record.Approval,
 	}
-	if req.Deploy {
-		count++
+}
+
+// gitManagerFor returns the Git manager of a named configuration
+func (bm *BuildManager) gitManagerFor(name string) (*GitManager, bool) {
+	gitManager, exists := bm.gitManagers[name]
+	return gitManager, exists
+}
+
+// lookupBuild returns the state of a live build, falling back to the history store
+func (bm *BuildManager) lookupBuild(id string) (BuildRecord, bool) {
+	if build, exists := bm.builds.Get(id); exists {
+		return build.Snapshot(), true
 	}
-	if count == 0 {
-		return 1 // Avoid division by zero
+	return bm.history.Get(id)
+}
+
+// buildTimeout returns the deadline of a whole build, 0 meaning none
+func (bm *BuildManager) buildTimeout() time.Duration {
+	return time.Duration(bm.config.Build.BuildTimeoutSeconds) * time.Second
+}
+
+// stepLabel returns the name shown for a step in build logs
+func stepLabel(step PipelineStep) string {
+	if step.Description != "" {
+		return fmt.Sprintf("%s (%s)", step.Name, step.Description)
 	}
-	return count
-}
\ No newline at end of file
+	return step.Name
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Health Checks
+// =============================================================================
+
+// Health check defaults used when config.yaml leaves a setting unset
+const (
+	defaultHealthCheckStatus   = http.StatusOK
+	defaultHealthCheckRetries  = 10
+	defaultHealthCheckInterval = 5 * time.Second
+	defaultHealthCheckTimeout  = 5 * time.Minute
+	healthCheckRequestTimeout  = 10 * time.Second
+	healthCheckBodyLimit       = 64 * 1024
+)
+
+// checkDeployment runs the health check that the build's config.yaml
+// configures for the target environment after a deploy step succeeded
+func (bm *BuildManager) checkDeployment(run *buildRun, step PipelineStep, workspace string) error {
+	config, err := loadBranchConfig(workspace)
+	if err != nil {
+		return fmt.Errorf("failed to load deployment settings: %v", err)
+	}
+	deployment := config.Deployment
+	if deployment.HealthCheckEndpoint == "" {
+		return nil
+	}
+
+	return bm.runHealthCheck(run, step, deployment, run.req.Environment)
+}
+
+// healthCheckURL fills the {host} and {environment} placeholders of the
+// configured endpoint for an environment
+func healthCheckURL(deployment DeploymentConfig, environment string) (string, error) {
+	url := deployment.HealthCheckEndpoint
+	if strings.Contains(url, "{host}") {
+		host, exists := deployment.HealthCheck.Hosts[environment]
+		if !exists || host == "" {
+			return "", fmt.Errorf("no health check host configured for environment %q", environment)
+		}
+		url = strings.ReplaceAll(url, "{host}", host)
+	}
+	return strings.ReplaceAll(url, "{environment}", environment), nil
+}
+
+// runHealthCheck polls the health check endpoint of an environment after a
+// deploy step until it answers with the expected status and body, logging
+// every probe. It fails once the retries or the overall timeout run out.
+func (bm *BuildManager) runHealthCheck(run *buildRun, step PipelineStep, deployment DeploymentConfig, environment string) error {
+	url, err := healthCheckURL(deployment, environment)
+	if err != nil {
+		return err
+	}
+
+	check := deployment.HealthCheck
+	expectedStatus := check.ExpectedStatus
+	if expectedStatus == 0 {
+		expectedStatus = defaultHealthCheckStatus
+	}
+	retries := check.Retries
+	if retries <= 0 {
+		retries = defaultHealthCheckRetries
+	}
+	interval := time.Duration(check.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	timeout := time.Duration(check.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(run.build.Context(), timeout)
+	defer cancel()
+
+	logFunc := run.stepLogger(step)
+	logFunc(newLogMessage(fmt.Sprintf("🩺 開始健康檢查 %s (環境 %s)", url, environment), "info"))
+
+	var lastErr error
+	for probe := 1; probe <= retries; probe++ {
+		lastErr = probeHealth(ctx, url, expectedStatus, check.ExpectedBody)
+		if lastErr == nil {
+			logFunc(newLogMessage(fmt.Sprintf("✅ 健康檢查 %d/%d 通過", probe, retries), "success"))
+			return nil
+		}
+		logFunc(newLogMessage(fmt.Sprintf("🩺 健康檢查 %d/%d 未通過: %v", probe, retries, lastErr), "warning"))
+
+		if probe == retries {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			if run.build.Context().Err() != nil {
+				return fmt.Errorf("health check cancelled")
+			}
+			return fmt.Errorf("health check timed out after %s: %v", timeout, lastErr)
+		case <-time.After(interval):
+		}
+	}
+	return fmt.Errorf("health check failed after %d probes: %v", retries, lastErr)
+}
+
+// probeHealth sends one request to url and checks the response
+func probeHealth(ctx context.Context, url string, expectedStatus int, expectedBody string) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("invalid health check endpoint: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, healthCheckBodyLimit))
+	if err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+	if resp.StatusCode != expectedStatus {
+		return fmt.Errorf("HTTP %d, expected %d", resp.StatusCode, expectedStatus)
+	}
+	if expectedBody != "" && !strings.Contains(string(body), expectedBody) {
+		return fmt.Errorf("HTTP %d, response does not contain %q", resp.StatusCode, expectedBody)
+	}
+	return nil
+}
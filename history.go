@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =============================================================================
+// Data Structures
+// =============================================================================
+
+// HistoryStore persists build records as JSON files under the data directory
+type HistoryStore struct {
+	dir string
+
+	mu      sync.RWMutex
+	records map[string]BuildRecord
+}
+
+// HistoryFilter selects build records from the history store
+type HistoryFilter struct {
+	GitConfig string
+	Branch    string
+	Status    BuildStatus
+	User      string
+	From      time.Time
+	To        time.Time
+	Limit     int
+}
+
+// =============================================================================
+// Constructor
+// =============================================================================
+
+// NewHistoryStore opens the history store in dataDir, loading existing records
+func NewHistoryStore(dataDir string) (*HistoryStore, error) {
+	dir := filepath.Join(dataDir, "builds")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %v", err)
+	}
+
+	hs := &HistoryStore{
+		dir:     dir,
+		records: make(map[string]BuildRecord),
+	}
+	if err := hs.load(); err != nil {
+		return nil, err
+	}
+	return hs, nil
+}
+
+// load reads every stored record into memory. Builds that were still active
+// when the server stopped are marked as failed.
+func (hs *HistoryStore) load() error {
+	files, err := filepath.Glob(filepath.Join(hs.dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list build history: %v", err)
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Printf("Error reading build record %s: %v", file, err)
+			continue
+		}
+
+		var record BuildRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			log.Printf("Error parsing build record %s: %v", file, err)
+			continue
+		}
+
+		if !record.Status.IsFinal() {
+			record.Status = BuildStatusFailed
+			record.Error = "build interrupted by server restart"
+			if err := hs.write(record); err != nil {
+				log.Printf("Error updating interrupted build %s: %v", record.ID, err)
+			}
+		}
+		hs.records[record.ID] = record
+	}
+
+	log.Printf("Loaded %d build records from %s", len(hs.records), hs.dir)
+	return nil
+}
+
+// =============================================================================
+// Store Operations
+// =============================================================================
+
+// Save writes a build record to disk and updates the in-memory index
+func (hs *HistoryStore) Save(record BuildRecord) error {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	if err := hs.write(record); err != nil {
+		return err
+	}
+	hs.records[record.ID] = record
+	return nil
+}
+
+// Get returns a stored build record by ID
+func (hs *HistoryStore) Get(id string) (BuildRecord, bool) {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+	record, ok := hs.records[id]
+	return record, ok
+}
+
+// Query returns the records matching filter, newest first
+func (hs *HistoryStore) Query(filter HistoryFilter) []BuildRecord {
+	hs.mu.RLock()
+	results := []BuildRecord{}
+	for _, record := range hs.records {
+		if filter.matches(record) {
+			results = append(results, record)
+		}
+	}
+	hs.mu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].CreatedAt.After(results[j].CreatedAt)
+	})
+
+	if filter.Limit > 0 && len(results) > filter.Limit {
+		results = results[:filter.Limit]
+	}
+	return results
+}
+
+// LastDeployment returns the newest build of a branch that deployed
+// successfully to an environment, ignoring the build with ID exclude
+func (hs *HistoryStore) LastDeployment(gitConfig, branch, environment, exclude string) (BuildRecord, bool) {
+	for _, record := range hs.Query(HistoryFilter{GitConfig: gitConfig, Branch: branch}) {
+		if record.ID != exclude && record.Request.Branch == branch && record.Request.Environment == environment && record.deployed() {
+			return record, true
+		}
+	}
+	return BuildRecord{}, false
+}
+
+// write atomically stores a record as <id>.json
+func (hs *HistoryStore) write(record BuildRecord) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode build record: %v", err)
+	}
+
+	path := filepath.Join(hs.dir, record.ID+".json")
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write build record: %v", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to store build record: %v", err)
+	}
+	return nil
+}
+
+// =============================================================================
+// Filtering
+// =============================================================================
+
+// matches reports whether a record satisfies every set filter field
+func (f HistoryFilter) matches(record BuildRecord) bool {
+	if f.GitConfig != "" && record.Request.GitConfig != f.GitConfig {
+		return false
+	}
+	if f.Branch != "" && !strings.Contains(record.Request.Branch, f.Branch) {
+		return false
+	}
+	if f.Status != "" && record.Status != f.Status {
+		return false
+	}
+	if f.User != "" && !strings.EqualFold(record.Request.User, f.User) {
+		return false
+	}
+	if !f.From.IsZero() && record.CreatedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !record.CreatedAt.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// parseHistoryTime parses a filter date given as RFC 3339 or YYYY-MM-DD.
+// Plain dates used as an upper bound include the whole day.
+func parseHistoryTime(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
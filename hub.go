@@ -0,0 +1,205 @@
+package main
+
+import (
+	"sync"
+)
+
+// =============================================================================
+// Data Structures
+// =============================================================================
+
+// Build event types delivered to subscribers
+const (
+	EventBuild    = "build"
+	EventLog      = "log"
+	EventLogBatch = "logs" // Log backlog delivered in one event when joining a build
+	EventProgress = "progress"
+	EventStatus   = "status"
+	EventQueue    = "queue"
+	EventStep     = "step"
+)
+
+// BuildEvent is a single update about a build
+type BuildEvent struct {
+	Type    string      `json:"type"`
+	BuildID string      `json:"buildId"`
+	Data    interface{} `json:"data"`
+}
+
+// EventSubscriber receives build events
+type EventSubscriber interface {
+	SendEvent(event BuildEvent)
+}
+
+// EventHub fans out build events to any number of subscribers per build.
+// Each active build has a topic holding its log backlog so that late
+// subscribers can catch up before receiving live events.
+type EventHub struct {
+	mu     sync.RWMutex
+	topics map[string]*buildTopic
+}
+
+// buildTopic holds the subscribers and backlog of a single build
+type buildTopic struct {
+	mu          sync.Mutex
+	subscribers map[EventSubscriber]struct{}
+	logs        []LogMessage
+	progress    *BuildEvent
+	status      *BuildEvent
+	queue       *BuildEvent
+	steps       map[string]BuildEvent // Latest event per step
+}
+
+// =============================================================================
+// Constructor
+// =============================================================================
+
+// NewEventHub creates an empty event hub
+func NewEventHub() *EventHub {
+	return &EventHub{
+		topics: make(map[string]*buildTopic),
+	}
+}
+
+// =============================================================================
+// Topic Lifecycle
+// =============================================================================
+
+// Open creates the topic for a build
+func (h *EventHub) Open(buildID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, exists := h.topics[buildID]; !exists {
+		h.topics[buildID] = &buildTopic{subscribers: make(map[EventSubscriber]struct{})}
+	}
+}
+
+// Close removes the topic of a finished build together with its backlog
+func (h *EventHub) Close(buildID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.topics, buildID)
+}
+
+// topic looks up the topic of an active build
+func (h *EventHub) topic(buildID string) (*buildTopic, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	topic, ok := h.topics[buildID]
+	return topic, ok
+}
+
+// =============================================================================
+// Publishing and Subscribing
+// =============================================================================
+
+// Publish records an event in the build backlog and delivers it to every subscriber
+func (h *EventHub) Publish(event BuildEvent) {
+	topic, ok := h.topic(event.BuildID)
+	if !ok {
+		return
+	}
+
+	topic.mu.Lock()
+	defer topic.mu.Unlock()
+
+	switch event.Type {
+	case EventLog:
+		if msg, ok := event.Data.(LogMessage); ok {
+			topic.logs = append(topic.logs, msg)
+		}
+	case EventProgress:
+		topic.progress = &event
+	case EventStatus:
+		topic.status = &event
+	case EventQueue:
+		topic.queue = &event
+	case EventStep:
+		if step, ok := event.Data.(StepResult); ok {
+			if topic.steps == nil {
+				topic.steps = make(map[string]BuildEvent)
+			}
+			topic.steps[step.Name] = event
+		}
+	}
+
+	for sub := range topic.subscribers {
+		sub.SendEvent(event)
+	}
+}
+
+// Subscribe registers sub for events of an active build. The backlog of
+// logs, the latest progress, status and queue position and the latest
+// state of every step are delivered first. The log backlog is sent as a
+// single EventLogBatch so that a long backlog cannot overflow the
+// subscriber's send queue.
+// It returns false if the build has no open topic.
+func (h *EventHub) Subscribe(buildID string, sub EventSubscriber) bool {
+	topic, ok := h.topic(buildID)
+	if !ok {
+		return false
+	}
+
+	topic.mu.Lock()
+	defer topic.mu.Unlock()
+
+	if len(topic.logs) > 0 {
+		logs := append([]LogMessage(nil), topic.logs...)
+		sub.SendEvent(BuildEvent{Type: EventLogBatch, BuildID: buildID, Data: logs})
+	}
+	if topic.progress != nil {
+		sub.SendEvent(*topic.progress)
+	}
+	if topic.status != nil {
+		sub.SendEvent(*topic.status)
+	}
+	if topic.queue != nil {
+		sub.SendEvent(*topic.queue)
+	}
+	for _, event := range topic.steps {
+		sub.SendEvent(event)
+	}
+
+	topic.subscribers[sub] = struct{}{}
+	return true
+}
+
+// Unsubscribe removes sub from a build's subscribers
+func (h *EventHub) Unsubscribe(buildID string, sub EventSubscriber) {
+	topic, ok := h.topic(buildID)
+	if !ok {
+		return
+	}
+
+	topic.mu.Lock()
+	defer topic.mu.Unlock()
+	delete(topic.subscribers, sub)
+}
+
+// UnsubscribeAll removes sub from every build, e.g. when a client disconnects
+func (h *EventHub) UnsubscribeAll(sub EventSubscriber) {
+	h.mu.RLock()
+	topics := make([]*buildTopic, 0, len(h.topics))
+	for _, topic := range h.topics {
+		topics = append(topics, topic)
+	}
+	h.mu.RUnlock()
+
+	for _, topic := range topics {
+		topic.mu.Lock()
+		delete(topic.subscribers, sub)
+		topic.mu.Unlock()
+	}
+}
+
+// Logs returns the log backlog of an active build
+func (h *EventHub) Logs(buildID string) ([]LogMessage, bool) {
+	topic, ok := h.topic(buildID)
+	if !ok {
+		return nil, false
+	}
+
+	topic.mu.Lock()
+	defer topic.mu.Unlock()
+	return append([]LogMessage(nil), topic.logs...), true
+}
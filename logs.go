@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// =============================================================================
+// Log Messages
+// =============================================================================
+
+// maxLogLineLength is the longest line forwarded as a single log message;
+// longer lines are split into several messages
+const maxLogLineLength = 4096
+
+// Output stream names used to tag script output
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+var (
+	// ansiEscapePattern matches CSI and OSC terminal escape sequences
+	ansiEscapePattern = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+	// ansiColorPattern captures the parameters of SGR colour sequences
+	ansiColorPattern = regexp.MustCompile(`\x1b\[([0-9;]*)m`)
+)
+
+// newLogMessage creates a log message stamped with the current time
+func newLogMessage(message, msgType string) LogMessage {
+	now := time.Now()
+	return LogMessage{
+		Timestamp: now.Format("15:04:05"),
+		Time:      now,
+		Message:   message,
+		Type:      msgType,
+	}
+}
+
+// formatLogLine renders a log message as a line of plain text
+func formatLogLine(msg LogMessage) string {
+	stamp := msg.Timestamp
+	if !msg.Time.IsZero() {
+		stamp = msg.Time.Format("2006-01-02 15:04:05")
+	}
+
+	prefix := fmt.Sprintf("[%s] [%s]", stamp, strings.ToUpper(msg.Type))
+	if msg.Step != "" && msg.Platform != "" {
+		prefix += fmt.Sprintf(" [%s %s]", msg.Step, msg.Platform)
+	} else if msg.Step != "" {
+		prefix += fmt.Sprintf(" [%s]", msg.Step)
+	}
+	if msg.Attempt > 0 {
+		prefix += fmt.Sprintf(" [attempt %d]", msg.Attempt)
+	}
+	if msg.Stream != "" {
+		prefix += fmt.Sprintf(" [%s]", msg.Stream)
+	}
+	return fmt.Sprintf("%s %s\n", prefix, msg.Message)
+}
+
+// =============================================================================
+// Output Processing
+// =============================================================================
+
+// streamLines reads r line by line and calls fn for every non-empty line.
+// Carriage returns are treated as line breaks so progress output is not
+// merged, and lines longer than maxLogLineLength are split.
+func streamLines(r io.Reader, fn func(line string)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 2*maxLogLineLength)
+	scanner.Split(scanLogLines)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		fn(line)
+	}
+	return scanner.Err()
+}
+
+// scanLogLines is a bufio.SplitFunc splitting on \n, \r\n or \r and
+// breaking overlong lines on a UTF-8 rune boundary
+func scanLogLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 && i <= maxLogLineLength {
+		if data[i] == '\r' {
+			if i+1 == len(data) && !atEOF {
+				// Need more data to tell \r from \r\n
+				return 0, nil, nil
+			}
+			if i+1 < len(data) && data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+		}
+		return i + 1, data[:i], nil
+	}
+
+	// Break a line only once it is known to be too long; with exactly
+	// maxLogLineLength bytes buffered the next byte may still be a newline
+	if len(data) > maxLogLineLength {
+		n := maxLogLineLength
+		for n > 0 && !utf8.RuneStart(data[n]) {
+			n--
+		}
+		if n == 0 {
+			n = maxLogLineLength
+		}
+		return n, data[:n], nil
+	}
+
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// stripANSI removes terminal escape sequences from a line
+func stripANSI(line string) string {
+	return ansiEscapePattern.ReplaceAllString(line, "")
+}
+
+// ansiLogType maps the foreground colour used in a line to a log type,
+// returning fallback when the line carries no recognised colour
+func ansiLogType(line, fallback string) string {
+	for _, match := range ansiColorPattern.FindAllStringSubmatch(line, -1) {
+		for _, code := range bytes.Split([]byte(match[1]), []byte(";")) {
+			switch string(code) {
+			case "31", "91":
+				return "error"
+			case "33", "93":
+				return "warning"
+			case "32", "92":
+				return "success"
+			}
+		}
+	}
+	return fallback
+}
+
+// =============================================================================
+// Log Archive
+// =============================================================================
+
+// LogArchive stores the complete log of every build as gzip-compressed JSON lines
+type LogArchive struct {
+	dir string
+}
+
+// LogWriter appends log messages to a single build's archive file
+type LogWriter struct {
+	mu   sync.Mutex
+	file *os.File
+	gz   *gzip.Writer
+	enc  *json.Encoder
+}
+
+// NewLogArchive opens the log archive in dataDir
+func NewLogArchive(dataDir string) (*LogArchive, error) {
+	dir := filepath.Join(dataDir, "logs")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %v", err)
+	}
+	return &LogArchive{dir: dir}, nil
+}
+
+// path returns the archive file path for a build
+func (la *LogArchive) path(buildID string) string {
+	return filepath.Join(la.dir, buildID+".log.gz")
+}
+
+// Create opens a new archive file for a build
+func (la *LogArchive) Create(buildID string) (*LogWriter, error) {
+	file, err := os.Create(la.path(buildID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log archive: %v", err)
+	}
+
+	gz := gzip.NewWriter(file)
+	return &LogWriter{
+		file: file,
+		gz:   gz,
+		enc:  json.NewEncoder(gz),
+	}, nil
+}
+
+// Read returns every message archived for a build. A partially written
+// archive (for example after a crash) yields the messages read so far.
+func (la *LogArchive) Read(buildID string) ([]LogMessage, error) {
+	file, err := os.Open(la.path(buildID))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log archive: %v", err)
+	}
+	defer gz.Close()
+
+	messages := []LogMessage{}
+	dec := json.NewDecoder(gz)
+	for {
+		var msg LogMessage
+		if err := dec.Decode(&msg); err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				log.Printf("Log archive for build %s is incomplete: %v", buildID, err)
+			}
+			break
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// Cleanup removes archives older than the retention period
+func (la *LogArchive) Cleanup(retention time.Duration) {
+	files, err := filepath.Glob(filepath.Join(la.dir, "*.log.gz"))
+	if err != nil {
+		log.Printf("Error listing log archives: %v", err)
+		return
+	}
+
+	cutoff := time.Now().Add(-retention)
+	removed := 0
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			log.Printf("Error removing log archive %s: %v", file, err)
+			continue
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		log.Printf("Removed %d log archives older than %s", removed, retention)
+	}
+}
+
+// Write appends a message to the archive
+func (lw *LogWriter) Write(msg LogMessage) error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	if lw.enc == nil {
+		return fmt.Errorf("log archive is closed")
+	}
+	return lw.enc.Encode(msg)
+}
+
+// Close flushes and closes the archive
+func (lw *LogWriter) Close() error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	if lw.enc == nil {
+		return nil
+	}
+	lw.enc = nil
+
+	if err := lw.gz.Close(); err != nil {
+		lw.file.Close()
+		return err
+	}
+	return lw.file.Close()
+}
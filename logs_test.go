@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestScanLogLines(t *testing.T) {
+	long := strings.Repeat("a", maxLogLineLength)
+	// A three byte rune occupying indexes 4094-4096 straddles the limit
+	straddling := strings.Repeat("a", maxLogLineLength-2) + "€"
+
+	tests := []struct {
+		name    string
+		data    string
+		atEOF   bool
+		advance int
+		token   string
+		wantNil bool
+	}{
+		{name: "empty at EOF", data: "", atEOF: true, wantNil: true},
+		{name: "partial line", data: "abc", wantNil: true},
+		{name: "partial line at EOF", data: "abc", atEOF: true, advance: 3, token: "abc"},
+		{name: "newline", data: "abc\ndef", advance: 4, token: "abc"},
+		{name: "crlf", data: "abc\r\ndef", advance: 5, token: "abc"},
+		{name: "cr split from lf", data: "abc\r", wantNil: true},
+		{name: "cr at EOF", data: "abc\r", atEOF: true, advance: 4, token: "abc"},
+		{name: "lone cr", data: "abc\rdef", advance: 4, token: "abc"},
+		{name: "exactly max length", data: long, wantNil: true},
+		{name: "exactly max length at EOF", data: long, atEOF: true, advance: maxLogLineLength, token: long},
+		{name: "max length then newline", data: long + "\n", advance: maxLogLineLength + 1, token: long},
+		{name: "rune on the boundary", data: straddling, advance: maxLogLineLength - 2, token: strings.Repeat("a", maxLogLineLength-2)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			advance, token, err := scanLogLines([]byte(tt.data), tt.atEOF)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantNil {
+				if advance != 0 || token != nil {
+					t.Fatalf("got advance %d, token %q; want a request for more data", advance, token)
+				}
+				return
+			}
+			if advance != tt.advance || string(token) != tt.token {
+				t.Fatalf("got advance %d, token of %d bytes; want advance %d, token of %d bytes", advance, len(token), tt.advance, len(tt.token))
+			}
+		})
+	}
+}
+
+func TestStreamLinesSplitWrites(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   []string
+	}{
+		{
+			name:   "single write of max length",
+			writes: []string{strings.Repeat("x", maxLogLineLength)},
+			want:   []string{strings.Repeat("x", maxLogLineLength)},
+		},
+		{
+			name:   "crlf across writes",
+			writes: []string{"first\r", "\nsecond\n"},
+			want:   []string{"first", "second"},
+		},
+		{
+			name:   "overlong line",
+			writes: []string{strings.Repeat("y", maxLogLineLength+10) + "\n"},
+			want:   []string{strings.Repeat("y", maxLogLineLength), strings.Repeat("y", 10)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, w := io.Pipe()
+			go func() {
+				for _, chunk := range tt.writes {
+					w.Write([]byte(chunk))
+				}
+				w.Close()
+			}()
+
+			var got []string
+			if err := streamLines(r, func(line string) { got = append(got, line) }); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d lines, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("line %d: got %d bytes, want %d bytes", i, len(got[i]), len(tt.want[i]))
+				}
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"io/fs"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
@@ -21,29 +22,56 @@ var templateFiles embed.FS
 
 // BuildManager handles the build operations
 type BuildManager struct {
-	config     *config.Config
-	gitManager *GitManager
-	upgrader   websocket.Upgrader
+	config      *config.Config
+	gitManagers map[string]*GitManager
+	builds      *BuildStore
+	history     *HistoryStore
+	logs        *LogArchive
+	events      *EventHub
+	queue       *BuildQueue
+	approvals   *ApprovalGates
+	upgrader    websocket.Upgrader
 }
 
 // NewBuildManager creates a new build manager instance
 func NewBuildManager(cfg *config.Config) *BuildManager {
-	// Get first git config as default
-	var defaultGitConfig config.GitConfig
-	for _, gitConfig := range cfg.GitConfigs {
-		defaultGitConfig = gitConfig
-		break
+	// Create one Git manager per configuration
+	gitManagers := make(map[string]*GitManager, len(cfg.GitConfigs))
+	for name, gitConfig := range cfg.GitConfigs {
+		gitManagers[name] = NewGitManager(name, gitConfig)
+		if err := gitManagers[name].validateAuth(); err != nil {
+			log.Printf("Git configuration %s has invalid authentication settings: %v", name, err)
+		}
+	}
+
+	history, err := NewHistoryStore(cfg.Build.DataDir)
+	if err != nil {
+		log.Fatalf("Failed to open build history: %v", err)
+	}
+
+	logs, err := NewLogArchive(cfg.Build.DataDir)
+	if err != nil {
+		log.Fatalf("Failed to open log archive: %v", err)
 	}
 	
-	return &BuildManager{
-		config:     cfg,
-		gitManager: NewGitManager(defaultGitConfig),
+	bm := &BuildManager{
+		config:      cfg,
+		gitManagers: gitManagers,
+		builds:      NewBuildStore(),
+		history:     history,
+		logs:        logs,
+		events:      NewEventHub(),
+		approvals:   NewApprovalGates(),
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true // Allow all origins for development
 			},
 		},
 	}
+	bm.queue = NewBuildQueue(cfg.Build.MaxConcurrent, func(run *buildRun) {
+		go bm.handleBuildRequest(run)
+	})
+	return bm
 }
 
 func main() {
@@ -59,8 +87,12 @@ func main() {
 	// Create necessary directories
 	createDirectories()
 
+	// Start background maintenance
+	go bm.runLogRetention()
+	go bm.runWorkspaceRetention()
+
 	// Print startup info
-	printStartupInfo(cfg.Server.Port)
+	printStartupInfo(cfg.Server.Port, cfg.Build.DataDir)
 
 	// Start server
 	log.Fatal(http.ListenAndServe(":"+cfg.Server.Port, router))
@@ -72,10 +104,26 @@ func (bm *BuildManager) setupRoutes() *mux.Router {
 
 	// API routes
 	r.HandleFunc("/api/git-configs", bm.GetGitConfigs).Methods("GET")
+	r.HandleFunc("/api/git-configs/{gitConfig}/fetch", bm.FetchGitConfig).Methods("POST")
 	r.HandleFunc("/api/branches/{gitConfig}", bm.GetBranches).Methods("GET")
-	r.HandleFunc("/api/config/{gitConfig}/{branch}", bm.GetConfig).Methods("GET")
-	r.HandleFunc("/api/versions/{gitConfig}/{branch}", bm.GetVersions).Methods("GET")
-	r.HandleFunc("/api/release-notes/{gitConfig}/{branch}", bm.GetReleaseNotes).Methods("GET")
+	r.HandleFunc("/api/tags/{gitConfig}", bm.GetTags).Methods("GET")
+	r.HandleFunc("/api/config/{gitConfig}/{branch:.+}", bm.GetConfig).Methods("GET")
+	r.HandleFunc("/api/versions/{gitConfig}/{branch:.+}", bm.GetVersions).Methods("GET")
+	r.HandleFunc("/api/release-notes/{gitConfig}/{branch:.+}", bm.GetReleaseNotes).Methods("GET")
+	r.HandleFunc("/api/pipeline/{gitConfig}/{branch:.+}", bm.GetPipeline).Methods("GET")
+	r.HandleFunc("/api/builds", bm.GetBuildHistory).Methods("GET")
+	r.HandleFunc("/api/build", bm.StartBuild).Methods("POST")
+	r.HandleFunc("/api/queue", bm.GetQueue).Methods("GET")
+	r.HandleFunc("/api/queue/{id}/move", bm.MoveQueuedBuild).Methods("POST")
+	r.HandleFunc("/api/queue/{id}", bm.RemoveQueuedBuild).Methods("DELETE")
+	r.HandleFunc("/api/build/status/{id}", bm.GetBuildStatus).Methods("GET")
+	r.HandleFunc("/api/build/{id}/logs", bm.GetBuildLogs).Methods("GET")
+	r.HandleFunc("/api/build/{id}/log", bm.DownloadBuildLog).Methods("GET")
+	r.HandleFunc("/api/build/{id}/cancel", bm.CancelBuild).Methods("POST")
+	r.HandleFunc("/api/build/{id}/rerun", bm.RerunBuild).Methods("POST")
+	r.HandleFunc("/api/build/{id}/promote", bm.PromoteBuild).Methods("POST")
+	r.HandleFunc("/api/build/{id}/approve", bm.ApproveBuild).Methods("POST")
+	r.HandleFunc("/api/build/{id}/reject", bm.RejectBuild).Methods("POST")
 	r.HandleFunc("/ws", bm.HandleWebSocket)
 
 	// Serve static files from embedded FS
@@ -88,6 +136,19 @@ func (bm *BuildManager) setupRoutes() *mux.Router {
 	return r
 }
 
+// runLogRetention periodically removes build logs older than the configured retention
+func (bm *BuildManager) runLogRetention() {
+	if bm.config.Build.LogRetentionDays <= 0 {
+		return
+	}
+
+	retention := time.Duration(bm.config.Build.LogRetentionDays) * 24 * time.Hour
+	for {
+		bm.logs.Cleanup(retention)
+		time.Sleep(time.Hour)
+	}
+}
+
 // createDirectories creates necessary directories
 func createDirectories() {
 	dirs := []string{"repos", "build-temp"}
@@ -99,10 +160,11 @@ func createDirectories() {
 }
 
 // printStartupInfo prints server startup information
-func printStartupInfo(port string) {
+func printStartupInfo(port, dataDir string) {
 	fmt.Printf("🚀 Build Tool 啟動中...\n")
 	fmt.Printf("📱 Web UI: http://localhost:%s\n", port)
 	fmt.Printf("🔌 WebSocket: ws://localhost:%s/ws\n", port)
 	fmt.Printf("📁 Repos 目錄: %s\n", "repos")
 	fmt.Printf("📁 Build 暫存目錄: %s\n", "build-temp")
+	fmt.Printf("📁 資料目錄: %s\n", dataDir)
 }
\ No newline at end of file
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// =============================================================================
+// Build Matrix
+// =============================================================================
+
+// executeMatrix runs a step once per platform, up to build.max_parallel_steps
+// platforms at a time. Platforms share the build workspace, so outputs stay
+// visible to later steps and re-runs; each gets its own output directory in
+// BUILD_OUTPUT_DIR to write to. With fail-fast the remaining platforms are
+// cancelled as soon as one fails; otherwise every platform runs to
+// completion. The step fails if any platform failed.
+func (bm *BuildManager) executeMatrix(run *buildRun, step PipelineStep, workspace string) error {
+	limit := bm.config.Build.MaxParallelSteps
+	if limit < 1 {
+		limit = 1
+	}
+	failFast := step.FailFast == nil || *step.FailFast
+
+	ctx, cancel := context.WithCancel(run.build.Context())
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failures []string
+		slots    = make(chan struct{}, limit)
+	)
+
+	for _, platform := range step.Platforms {
+		wg.Add(1)
+		go func(platform string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			if run.build.Context().Err() != nil {
+				run.finishPlatform(step.Name, platform, run.build.interruptedStatus(), "")
+				return
+			}
+			if ctx.Err() != nil {
+				run.finishPlatform(step.Name, platform, BuildStatusCancelled, "") // Fail-fast
+				return
+			}
+
+			run.startPlatform(step.Name, platform)
+			logFunc := run.platformLogger(step, platform)
+			logFunc(newLogMessage(fmt.Sprintf("🧩 平台 %s 開始", platform), "info"))
+
+			outputDir, err := platformOutputDir(workspace, platform)
+			if err == nil {
+				env := append(run.stepEnv(step), platformEnv(platform)...)
+				env = append(env, "BUILD_OUTPUT_DIR="+outputDir)
+				err = bm.runStepWithRetry(ctx, run, step, workspace, env, logFunc, func(attempt int) {
+					run.setPlatformAttempt(step.Name, platform, attempt)
+				})
+			}
+			switch {
+			case err == nil:
+				logFunc(newLogMessage(fmt.Sprintf("✅ 平台 %s 完成", platform), "success"))
+				run.finishPlatform(step.Name, platform, BuildStatusCompleted, "")
+			case run.build.Context().Err() != nil:
+				run.finishPlatform(step.Name, platform, run.build.interruptedStatus(), "")
+			case ctx.Err() != nil:
+				run.finishPlatform(step.Name, platform, BuildStatusCancelled, "") // Fail-fast
+			default:
+				logFunc(newLogMessage(fmt.Sprintf("❌ 平台 %s 失敗: %v", platform, err), "error"))
+				run.finishPlatform(step.Name, platform, failureStatus(err), err.Error())
+
+				mu.Lock()
+				failures = append(failures, platform)
+				mu.Unlock()
+				if failFast {
+					cancel()
+				}
+			}
+		}(platform)
+	}
+	wg.Wait()
+
+	if len(failures) > 0 {
+		return fmt.Errorf("platforms failed: %s", strings.Join(failures, ", "))
+	}
+	return nil
+}
+
+// platformOutputDirName is the directory of the build workspace holding one
+// output directory per platform of a matrix step
+const platformOutputDirName = "build-output"
+
+// platformOutputDir creates the output directory of a platform, such as
+// build-output/linux-arm64 in the workspace, and returns its absolute path.
+// Platforms running at the same time write their results there instead of
+// overwriting each other's files.
+func platformOutputDir(workspace, platform string) (string, error) {
+	dir, err := filepath.Abs(filepath.Join(workspace, platformOutputDirName, strings.ReplaceAll(platform, "/", "-")))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve output directory: %v", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create output directory %s: %v", dir, err)
+	}
+	return dir, nil
+}
+
+// platformEnv returns the environment describing a target platform such as
+// linux/arm64 or linux/arm/v7, using the names Docker buildx passes to builds
+func platformEnv(platform string) []string {
+	env := []string{"BUILD_PLATFORM=" + platform}
+
+	parts := strings.SplitN(platform, "/", 3)
+	env = append(env, "TARGETOS="+parts[0])
+	if len(parts) > 1 {
+		env = append(env, "TARGETARCH="+parts[1])
+	}
+	if len(parts) > 2 {
+		env = append(env, "TARGETVARIANT="+parts[2])
+	}
+	return env
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Repository Mirror
+// =============================================================================
+
+// defaultFetchInterval is how long mirror contents are served before reads
+// fetch again, when the Git configuration sets no fetch_interval_seconds
+const defaultFetchInterval = time.Minute
+
+// fetchTimeout bounds fetches made outside a pull step, such as pinning a
+// build's commit or refreshing a stale mirror, so a hanging remote cannot
+// hold the mirror lock and every request waiting on it indefinitely
+const fetchTimeout = 2 * time.Minute
+
+// mirrorDir returns the bare mirror of the configuration's repository
+func (gm *GitManager) mirrorDir() string {
+	return filepath.Join("repos", gm.name+".git")
+}
+
+// fetchInterval returns how long fetched refs are considered fresh
+func (gm *GitManager) fetchInterval() time.Duration {
+	if gm.config.FetchIntervalSeconds > 0 {
+		return time.Duration(gm.config.FetchIntervalSeconds) * time.Second
+	}
+	return defaultFetchInterval
+}
+
+// Fetch updates the mirror from the remote repository, creating it on first
+// use. It gives up after fetchTimeout.
+func (gm *GitManager) Fetch() error {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	return gm.timeoutError(ctx, gm.FetchContext(ctx))
+}
+
+// FetchContext is Fetch with the git commands bound to ctx; cancelling ctx
+// kills them, so a hanging remote cannot hold the mirror lock indefinitely
+func (gm *GitManager) FetchContext(ctx context.Context) error {
+	gm.mirrorMu.Lock()
+	defer gm.mirrorMu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return gm.fetchLocked(ctx)
+}
+
+// refresh fetches the mirror if it is missing or older than the fetch
+// interval. If fetching an existing mirror fails, the refs already fetched
+// keep being served.
+func (gm *GitManager) refresh() error {
+	gm.mirrorMu.Lock()
+	defer gm.mirrorMu.Unlock()
+
+	if time.Since(gm.lastFetch) < gm.fetchInterval() {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	err := gm.timeoutError(ctx, gm.fetchLocked(ctx))
+	if err != nil && gm.hasMirror() {
+		log.Printf("Error refreshing mirror of %s, using cached refs: %v", gm.name, err)
+		return nil
+	}
+	return err
+}
+
+// fetchLocked clones or fetches the mirror; mirrorMu must be held
+func (gm *GitManager) fetchLocked(ctx context.Context) error {
+	if err := gm.validateAuth(); err != nil {
+		return err
+	}
+	dir := gm.mirrorDir()
+
+	if !gm.hasMirror() {
+		log.Printf("Creating mirror of %s in %s", gm.config.URL, dir)
+		if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+			return fmt.Errorf("failed to create mirror directory: %v", err)
+		}
+		cmd := gm.gitCommand(ctx, "clone", "--quiet", "--mirror", gm.config.URL, dir)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			os.RemoveAll(dir)
+			return fmt.Errorf("failed to create mirror: %v\nOutput: %s", err, string(output))
+		}
+	} else {
+		// Keep the remote URL in sync with the configuration. This also drops
+		// tokens that used to be embedded in the URL.
+		if _, err := gm.mirrorGitContext(ctx, "remote", "set-url", "origin", gm.config.URL); err != nil {
+			return err
+		}
+		if _, err := gm.mirrorGitContext(ctx, "fetch", "--quiet", "--prune", "origin"); err != nil {
+			return fmt.Errorf("failed to fetch %s: %v", gm.config.URL, err)
+		}
+	}
+
+	gm.lastFetch = time.Now()
+	return nil
+}
+
+// timeoutError replaces err with a clearer one when ctx ran out of time
+func (gm *GitManager) timeoutError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("fetching %s timed out after %s", gm.config.URL, fetchTimeout)
+	}
+	return err
+}
+
+// hasMirror reports whether the mirror has been created
+func (gm *GitManager) hasMirror() bool {
+	_, err := os.Stat(gm.mirrorDir())
+	return err == nil
+}
+
+// mirrorGit runs a git command against the mirror and returns its output
+func (gm *GitManager) mirrorGit(args ...string) ([]byte, error) {
+	return gm.mirrorGitContext(context.Background(), args...)
+}
+
+// mirrorGitContext is mirrorGit with the command bound to ctx
+func (gm *GitManager) mirrorGitContext(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := gm.gitCommand(ctx, append([]string{"--git-dir", gm.mirrorDir()}, args...)...)
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("git %s: %v", args[0], err)
+	}
+	return output, nil
+}
+
+// gitCommand prepares an authenticated git command. Like runProcess it runs in
+// its own process group, so cancelling ctx also kills the helpers git starts
+// (ssh, credential helpers).
+func (gm *GitManager) gitCommand(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = append(os.Environ(), gm.gitEnv()...)
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+	cmd.WaitDelay = 5 * time.Second
+	return cmd
+}
+
+// ResolveBranch returns the commit a branch points to in the mirror
+func (gm *GitManager) ResolveBranch(branchName string) (string, error) {
+	output, err := gm.mirrorGit("rev-parse", "--verify", "--quiet", "refs/heads/"+branchName+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("branch %s not found", branchName)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ResolveRef returns the commit a branch, tag or (abbreviated) commit SHA
+// points to in the mirror. Branches take precedence over tags of the same name.
+func (gm *GitManager) ResolveRef(ref string) (string, error) {
+	if ref == "" || strings.HasPrefix(ref, "-") {
+		return "", fmt.Errorf("invalid ref %q", ref)
+	}
+	for _, candidate := range []string{"refs/heads/" + ref, "refs/tags/" + ref, ref} {
+		output, err := gm.mirrorGit("rev-parse", "--verify", "--quiet", candidate+"^{commit}")
+		if err == nil {
+			return strings.TrimSpace(string(output)), nil
+		}
+	}
+	return "", fmt.Errorf("ref %s not found", ref)
+}
+
+// ReadFile returns the contents of a file at a branch, tag or commit without
+// checking it out, refreshing the mirror first if it is stale
+func (gm *GitManager) ReadFile(ref, path string) ([]byte, error) {
+	if err := gm.refresh(); err != nil {
+		return nil, fmt.Errorf("failed to fetch repository: %v", err)
+	}
+	commit, err := gm.ResolveRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	return gm.showFile(commit, path)
+}
+
+// showFile returns the contents of a file at a commit of the mirror
+func (gm *GitManager) showFile(commit, path string) ([]byte, error) {
+	output, err := gm.mirrorGit("show", commit+":"+path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return output, nil
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// =============================================================================
+// Data Structures
+// =============================================================================
+
+// Pipeline step types. Pull steps update the branch checkout; every other
+// type runs a script or command in the build workspace. The build, push and
+// deploy types mark steps that the build tool treats specially.
+const (
+	StepTypePull   = "pull"
+	StepTypeBuild  = "build"
+	StepTypePush   = "push"
+	StepTypeDeploy = "deploy"
+	StepTypeScript = "script"
+)
+
+// PipelineStep is a named step declared in the pipeline section of config.yaml
+type PipelineStep struct {
+	Name            string            `yaml:"name" json:"name"`
+	Description     string            `yaml:"description" json:"description,omitempty"`
+	Type            string            `yaml:"type" json:"type"`
+	Script          string            `yaml:"script" json:"script,omitempty"`
+	Command         string            `yaml:"command" json:"command,omitempty"`
+	Env             map[string]string `yaml:"env" json:"env,omitempty"`
+	Timeout         int               `yaml:"timeout" json:"timeout,omitempty"` // Seconds, 0 uses build.step_timeout_seconds
+	ContinueOnError bool              `yaml:"continue_on_error" json:"continue_on_error,omitempty"`
+	DependsOn       []string          `yaml:"depends_on" json:"depends_on,omitempty"`
+	Optional        bool              `yaml:"optional" json:"optional,omitempty"` // Not selected unless requested
+
+	// Platforms runs the step once per platform. Build steps default to build.platforms.
+	Platforms []string `yaml:"platforms" json:"platforms,omitempty"`
+	// FailFast cancels the remaining platforms once one fails. Defaults to build.fail_fast.
+	FailFast *bool `yaml:"fail_fast" json:"fail_fast,omitempty"`
+	// Retry re-runs the step, or each platform of a matrix step, when it fails
+	Retry *RetryPolicy `yaml:"retry" json:"retry,omitempty"`
+	// Rollback restores the previous deployment when a deploy step fails
+	Rollback *RollbackAction `yaml:"rollback" json:"rollback,omitempty"`
+}
+
+// =============================================================================
+// Pipeline Definitions
+// =============================================================================
+
+// DefaultPipeline returns the pipeline used by branches whose config.yaml
+// declares none. It mirrors the original fixed steps.
+func DefaultPipeline() []PipelineStep {
+	return []PipelineStep{
+		{Name: StepPull, Description: "拉取配置倉庫", Type: StepTypePull},
+		{Name: StepBuild, Description: "執行構建腳本", Type: StepTypeBuild, Script: "scripts/build.sh", DependsOn: []string{StepPull}},
+		{Name: StepPush, Description: "推送到 Harbor", Type: StepTypePush, Script: "scripts/push.sh", ContinueOnError: true, DependsOn: []string{StepBuild}, Retry: &RetryPolicy{Count: 2, Delay: 10}},
+		{Name: StepDeploy, Description: "執行部署", Type: StepTypeDeploy, Script: "scripts/deploy.sh", DependsOn: []string{StepPush}, Optional: true, Rollback: &RollbackAction{Script: "scripts/rollback.sh"}},
+	}
+}
+
+// resolvePipeline validates the steps declared in config.yaml and returns
+// them in execution order. An empty declaration yields the default pipeline,
+// and a pipeline without a pull step gets the built-in one prepended so the
+// branch checkout is still kept up to date. Build steps inherit the platform
+// matrix and fail-fast policy of the build settings.
+func resolvePipeline(declared []PipelineStep, settings BuildSettings) ([]PipelineStep, error) {
+	if len(declared) == 0 {
+		declared = DefaultPipeline()
+	}
+
+	failFast := settings.FailFast == nil || *settings.FailFast
+	steps := make([]PipelineStep, 0, len(declared)+1)
+	hasPull := false
+	for _, step := range declared {
+		if step.Type == "" {
+			step.Type = StepTypeScript
+		}
+		if step.Type == StepTypePull {
+			hasPull = true
+		}
+		if step.Type == StepTypeBuild && len(step.Platforms) == 0 {
+			step.Platforms = settings.Platforms
+		}
+		if len(step.Platforms) > 0 && step.FailFast == nil {
+			step.FailFast = &failFast
+		}
+		steps = append(steps, step)
+	}
+	if !hasPull {
+		steps = append([]PipelineStep{DefaultPipeline()[0]}, steps...)
+	}
+
+	if err := validatePipeline(steps); err != nil {
+		return nil, err
+	}
+	return orderPipeline(steps)
+}
+
+// validatePipeline checks step names, types, actions and dependencies
+func validatePipeline(steps []PipelineStep) error {
+	names := make(map[string]bool, len(steps))
+	for _, step := range steps {
+		if step.Name == "" {
+			return fmt.Errorf("pipeline step without a name")
+		}
+		if names[step.Name] {
+			return fmt.Errorf("duplicate pipeline step %q", step.Name)
+		}
+		names[step.Name] = true
+
+		switch step.Type {
+		case StepTypePull:
+		case StepTypeBuild, StepTypePush, StepTypeDeploy, StepTypeScript:
+			if step.Script == "" && step.Command == "" {
+				return fmt.Errorf("pipeline step %q needs a script or command", step.Name)
+			}
+			if step.Script != "" && step.Command != "" {
+				return fmt.Errorf("pipeline step %q has both a script and a command", step.Name)
+			}
+		default:
+			return fmt.Errorf("pipeline step %q has unknown type %q", step.Name, step.Type)
+		}
+		if step.Timeout < 0 {
+			return fmt.Errorf("pipeline step %q has a negative timeout", step.Name)
+		}
+		if step.Retry != nil {
+			if step.Type == StepTypePull {
+				return fmt.Errorf("pull step %q cannot be retried", step.Name)
+			}
+			if err := step.Retry.validate(); err != nil {
+				return fmt.Errorf("pipeline step %q: %v", step.Name, err)
+			}
+		}
+		if step.Rollback != nil {
+			if step.Type != StepTypeDeploy {
+				return fmt.Errorf("pipeline step %q is not a deploy step and cannot roll back", step.Name)
+			}
+			if err := step.Rollback.validate(); err != nil {
+				return fmt.Errorf("pipeline step %q: %v", step.Name, err)
+			}
+		}
+		if step.Type == StepTypePull && len(step.Platforms) > 0 {
+			return fmt.Errorf("pull step %q cannot run per platform", step.Name)
+		}
+		platforms := make(map[string]bool, len(step.Platforms))
+		for _, platform := range step.Platforms {
+			if platform == "" || platforms[platform] {
+				return fmt.Errorf("pipeline step %q has an empty or duplicate platform", step.Name)
+			}
+			platforms[platform] = true
+		}
+	}
+
+	for _, step := range steps {
+		for _, dep := range step.DependsOn {
+			if !names[dep] {
+				return fmt.Errorf("pipeline step %q depends on unknown step %q", step.Name, dep)
+			}
+		}
+	}
+	return nil
+}
+
+// orderPipeline sorts steps so every step follows its dependencies, keeping
+// the declared order otherwise. It fails if the dependencies form a cycle.
+func orderPipeline(steps []PipelineStep) ([]PipelineStep, error) {
+	ordered := make([]PipelineStep, 0, len(steps))
+	done := make(map[string]bool, len(steps))
+	for len(ordered) < len(steps) {
+		progressed := false
+		for _, step := range steps {
+			if done[step.Name] || !dependenciesDone(step, done) {
+				continue
+			}
+			ordered = append(ordered, step)
+			done[step.Name] = true
+			progressed = true
+			break // Restart so earlier declared steps keep priority
+		}
+		if !progressed {
+			var cyclic []string
+			for _, step := range steps {
+				if !done[step.Name] {
+					cyclic = append(cyclic, step.Name)
+				}
+			}
+			return nil, fmt.Errorf("pipeline steps %v have circular dependencies", cyclic)
+		}
+	}
+	return ordered, nil
+}
+
+// dependenciesDone reports whether every dependency of step is in done
+func dependenciesDone(step PipelineStep, done map[string]bool) bool {
+	for _, dep := range step.DependsOn {
+		if !done[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+// =============================================================================
+// Step Selection
+// =============================================================================
+
+// selectSteps returns the pipeline steps a request asks for, in execution
+// order. Requests name steps explicitly; older clients that only send the
+// per-step booleans select steps by type. Dependencies on steps that were not
+// selected are treated as already satisfied.
+func selectSteps(pipeline []PipelineStep, req BuildRequest) ([]PipelineStep, error) {
+	selected := make(map[string]bool)
+	if len(req.Steps) > 0 {
+		known := make(map[string]bool, len(pipeline))
+		for _, step := range pipeline {
+			known[step.Name] = true
+		}
+		for _, name := range req.Steps {
+			if !known[name] {
+				return nil, fmt.Errorf("unknown pipeline step %q", name)
+			}
+			selected[name] = true
+		}
+	} else {
+		types := map[string]bool{
+			StepTypePull:   req.PullRepos,
+			StepTypeBuild:  req.BuildImages,
+			StepTypePush:   req.PushHarbor,
+			StepTypeDeploy: req.Deploy,
+		}
+		for _, step := range pipeline {
+			if types[step.Type] {
+				selected[step.Name] = true
+			}
+		}
+	}
+
+	steps := []PipelineStep{}
+	for _, step := range pipeline {
+		if selected[step.Name] {
+			steps = append(steps, step)
+		}
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("at least one build step must be selected")
+	}
+	return steps, nil
+}
+
+// rerunSteps returns the steps a re-run of parent from the named step
+// executes: that step, every step depending on it and every step that did
+// not complete in the parent. Pull steps are never repeated, since the re-run
+// builds the parent's commit.
+func rerunSteps(parent BuildRecord, from string) ([]PipelineStep, error) {
+	if len(parent.Pipeline) == 0 {
+		return nil, fmt.Errorf("build %s has no recorded pipeline", parent.ID)
+	}
+
+	rerun := map[string]bool{from: true}
+	found := false
+	for _, step := range parent.Pipeline {
+		if step.Name == from {
+			if step.Type == StepTypePull {
+				return nil, fmt.Errorf("cannot re-run from pull step %q, start a new build instead", from)
+			}
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("build %s has no step %q", parent.ID, from)
+	}
+
+	steps := []PipelineStep{}
+	for _, step := range parent.Pipeline { // Already in execution order
+		if step.Type == StepTypePull {
+			continue
+		}
+		for _, dep := range step.DependsOn {
+			if rerun[dep] {
+				rerun[step.Name] = true
+			}
+		}
+		if result := parent.step(step.Name); result == nil || result.Status != BuildStatusCompleted {
+			rerun[step.Name] = true
+		}
+		if rerun[step.Name] {
+			steps = append(steps, step)
+		}
+	}
+	return steps, nil
+}
+
+// stepEnv returns the extra environment variables passed to a step's process
+func (run *buildRun) stepEnv(step PipelineStep) []string {
+	record := run.build.Snapshot()
+	env := []string{
+		"BUILD_ID=" + record.ID,
+		"BUILD_GIT_CONFIG=" + record.Request.GitConfig,
+		"BUILD_BRANCH=" + record.Request.Branch,
+		"BUILD_REF_TYPE=" + record.Request.RefType,
+		"BUILD_COMMIT=" + record.CommitHash,
+		"BUILD_STEP=" + step.Name,
+	}
+	if record.Request.Environment != "" {
+		env = append(env, "DEPLOY_ENVIRONMENT="+record.Request.Environment)
+	}
+	for key, value := range step.Env {
+		env = append(env, key+"="+value)
+	}
+	return env
+}
+
+// =============================================================================
+// Pipeline Execution
+// =============================================================================
+
+// stepResult is the outcome of a step run by runPipeline
+type stepResult struct {
+	step PipelineStep
+	err  error
+}
+
+// runPipeline executes the selected steps as a dependency graph, running up
+// to build.max_parallel_steps steps whose dependencies have finished at the
+// same time. Script steps also wait for the pull step, as their workspace is
+// created from the pulled checkout. Steps running at the same time share the
+// build workspace, so independent steps must write to different paths, as
+// the platforms of a matrix step do with BUILD_OUTPUT_DIR. After a step fails
+// without continue_on_error no further steps are started; steps already
+// running are allowed to finish. When a step needs approval no further steps
+// are started either, and the approval is requested once the running steps
+// have finished, so nothing runs while the build is off the queue. It returns
+// the name of the step that failed the build.
+func (bm *BuildManager) runPipeline(run *buildRun) string {
+	limit := bm.config.Build.MaxParallelSteps
+	if limit < 1 {
+		limit = 1
+	}
+
+	ctx := run.build.Context()
+	results := make(chan stepResult)
+	started := make(map[string]bool, len(run.steps))
+	finished := make(map[string]bool, len(run.steps))
+	running := 0
+	failed := ""
+	var gate *PipelineStep // Step waiting for the approval gate to open
+	decided := false       // Whether the approval gate has been decided
+
+	for {
+		// Start every ready step while slots are free
+		for _, step := range run.steps {
+			if failed != "" || ctx.Err() != nil || running >= limit || gate != nil {
+				break
+			}
+			if started[step.Name] || !run.stepReady(step, finished) {
+				continue
+			}
+			if !decided && run.needsApproval(step) {
+				gated := step
+				gate = &gated
+				break
+			}
+
+			started[step.Name] = true
+			running++
+			run.startStep(step.Name)
+			go func(step PipelineStep) {
+				results <- stepResult{step: step, err: bm.executeStep(run, step)}
+			}(step)
+		}
+
+		if running == 0 {
+			if gate == nil || failed != "" || ctx.Err() != nil {
+				return failed
+			}
+
+			// Nothing else runs now, so the build can leave the queue while it waits
+			err := bm.awaitApproval(run, *gate)
+			if ctx.Err() != nil {
+				return failed
+			}
+			if err != nil {
+				started[gate.Name] = true
+				finished[gate.Name] = true
+				run.startStep(gate.Name)
+				run.recordStepResult(stepResult{step: *gate, err: err})
+				failed = gate.Name
+			}
+			gate = nil
+			decided = true
+			continue
+		}
+
+		result := <-results
+		running--
+		finished[result.step.Name] = true
+		if !run.recordStepResult(result) && failed == "" {
+			failed = result.step.Name
+		}
+		run.setProgress(100 * len(finished) / len(run.steps))
+		run.persist()
+	}
+}
+
+// stepReady reports whether every selected dependency of step has finished
+func (run *buildRun) stepReady(step PipelineStep, finished map[string]bool) bool {
+	for _, other := range run.steps {
+		if finished[other.Name] {
+			continue
+		}
+		if other.Type == StepTypePull && step.Type != StepTypePull {
+			return false
+		}
+		for _, dep := range step.DependsOn {
+			if dep == other.Name {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// recordStepResult records the outcome of a finished step and reports
+// whether the build may continue
+func (run *buildRun) recordStepResult(result stepResult) bool {
+	step, err := result.step, result.err
+	var rejected *approvalError
+	switch {
+	case run.build.Context().Err() != nil:
+		run.finishStep(step.Name, run.build.interruptedStatus(), "")
+		return true
+	case errors.As(err, &rejected):
+		// A rejected release must not ship, whatever continue_on_error says
+		run.logStep(step, fmt.Sprintf("❌ 步驟 %s 未獲核准: %v", step.Name, err), "error")
+		run.finishStep(step.Name, BuildStatusFailed, err.Error())
+		return false
+	case err != nil && step.ContinueOnError:
+		run.logStep(step, fmt.Sprintf("⚠️ 步驟 %s 失敗，繼續執行: %v", step.Name, err), "warning")
+		run.finishStep(step.Name, failureStatus(err), err.Error())
+		return true
+	case err != nil:
+		run.logStep(step, fmt.Sprintf("❌ 步驟 %s 失敗: %v", step.Name, err), "error")
+		run.finishStep(step.Name, failureStatus(err), err.Error())
+		return false
+	default:
+		run.finishStep(step.Name, BuildStatusCompleted, "")
+		return true
+	}
+}
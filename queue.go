@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// =============================================================================
+// Data Structures
+// =============================================================================
+
+// BuildQueue schedules builds with a global concurrency limit and at most
+// one running build per git-config/branch workspace
+type BuildQueue struct {
+	maxConcurrent int
+	start         func(run *buildRun)
+
+	mu       sync.Mutex
+	pending  []*buildRun
+	running  map[string]*buildRun     // Build ID -> run
+	locked   map[string]string        // Workspace key -> build ID
+	resuming map[string]chan struct{} // Paused builds waiting for a slot again
+}
+
+// QueueStatus describes the builds known to the queue
+type QueueStatus struct {
+	MaxConcurrent int           `json:"max_concurrent"`
+	Running       []BuildRecord `json:"running"`
+	Queued        []BuildRecord `json:"queued"`
+}
+
+// =============================================================================
+// Constructor
+// =============================================================================
+
+// NewBuildQueue creates a queue that calls start for every build it dispatches
+func NewBuildQueue(maxConcurrent int, start func(run *buildRun)) *BuildQueue {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	return &BuildQueue{
+		maxConcurrent: maxConcurrent,
+		start:         start,
+		running:       make(map[string]*buildRun),
+		locked:        make(map[string]string),
+		resuming:      make(map[string]chan struct{}),
+	}
+}
+
+// =============================================================================
+// Queue Operations
+// =============================================================================
+
+// Enqueue adds a build to the end of the queue and dispatches if possible
+func (q *BuildQueue) Enqueue(run *buildRun) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pending = append(q.pending, run)
+	q.dispatch()
+}
+
+// Done releases the slot and workspace lock held by a finished build
+func (q *BuildQueue) Done(run *buildRun) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	id := run.build.ID()
+	if _, ok := q.running[id]; !ok {
+		return
+	}
+	delete(q.running, id)
+	if q.locked[run.workspaceKey()] == id {
+		delete(q.locked, run.workspaceKey())
+	}
+	q.dispatch()
+}
+
+// Pause releases the slot and workspace lock of a running build while it
+// waits for something outside the server, such as an approval, so that other
+// builds can run in the meantime
+func (q *BuildQueue) Pause(run *buildRun) {
+	q.Done(run)
+}
+
+// Resume puts a paused build at the front of the queue and blocks until it
+// holds a slot and its workspace lock again, or ctx is done
+func (q *BuildQueue) Resume(ctx context.Context, run *buildRun) error {
+	id := run.build.ID()
+	ready := make(chan struct{})
+
+	q.mu.Lock()
+	q.resuming[id] = ready
+	q.pending = append([]*buildRun{run}, q.pending...)
+	q.dispatch()
+	q.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, waiting := q.resuming[id]; waiting {
+		delete(q.resuming, id)
+		q.removePending(id)
+		run.setQueuePosition(0)
+		q.notifyPositions()
+	}
+	// A slot obtained meanwhile is released by Done when the build finishes
+	return ctx.Err()
+}
+
+// Remove drops a queued build and returns it. Running builds, including
+// paused builds waiting to resume, are not affected.
+func (q *BuildQueue) Remove(id string) (*buildRun, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, resuming := q.resuming[id]; resuming {
+		return nil, false
+	}
+	for i, run := range q.pending {
+		if run.build.ID() == id {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			run.build.setQueuePosition(0)
+			q.notifyPositions()
+			return run, true
+		}
+	}
+	return nil, false
+}
+
+// Move places a queued build at position (1-based) in the queue
+func (q *BuildQueue) Move(id string, position int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	index := -1
+	for i, run := range q.pending {
+		if run.build.ID() == id {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return fmt.Errorf("build %s is not queued", id)
+	}
+
+	if position < 1 {
+		position = 1
+	}
+	if position > len(q.pending) {
+		position = len(q.pending)
+	}
+
+	run := q.pending[index]
+	q.pending = append(q.pending[:index], q.pending[index+1:]...)
+	q.pending = append(q.pending[:position-1], append([]*buildRun{run}, q.pending[position-1:]...)...)
+
+	// A build moved ahead may now be able to start
+	q.dispatch()
+	return nil
+}
+
+// Status returns the running and queued builds in queue order
+func (q *BuildQueue) Status() QueueStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	status := QueueStatus{
+		MaxConcurrent: q.maxConcurrent,
+		Running:       []BuildRecord{},
+		Queued:        []BuildRecord{},
+	}
+	for _, run := range q.running {
+		status.Running = append(status.Running, run.build.Snapshot())
+	}
+	for _, run := range q.pending {
+		status.Queued = append(status.Queued, run.build.Snapshot())
+	}
+	return status
+}
+
+// =============================================================================
+// Scheduling
+// =============================================================================
+
+// dispatch starts queued builds, in order, while slots are free. Builds whose
+// workspace is in use are skipped so later builds for other branches can run.
+// Callers must hold q.mu.
+func (q *BuildQueue) dispatch() {
+	remaining := q.pending[:0]
+	for _, run := range q.pending {
+		key := run.workspaceKey()
+		if len(q.running) >= q.maxConcurrent || q.locked[key] != "" {
+			remaining = append(remaining, run)
+			continue
+		}
+
+		q.running[run.build.ID()] = run
+		q.locked[key] = run.build.ID()
+		run.setQueuePosition(0)
+		if ready, resuming := q.resuming[run.build.ID()]; resuming {
+			delete(q.resuming, run.build.ID())
+			close(ready)
+			continue
+		}
+		q.start(run)
+	}
+	q.pending = remaining
+	q.notifyPositions()
+}
+
+// removePending drops a build from the pending list. Callers must hold q.mu.
+func (q *BuildQueue) removePending(id string) {
+	for i, run := range q.pending {
+		if run.build.ID() == id {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// notifyPositions tells every queued build its current position.
+// Callers must hold q.mu.
+func (q *BuildQueue) notifyPositions() {
+	for i, run := range q.pending {
+		run.setQueuePosition(i + 1)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// =============================================================================
+// Retry Policy
+// =============================================================================
+
+// defaultRetryBackoff multiplies the delay between attempts when a policy sets none
+const defaultRetryBackoff = 2.0
+
+// RetryPolicy retries a failed step with exponential backoff
+type RetryPolicy struct {
+	Count     int     `yaml:"count" json:"count"`                     // Retries after the first attempt
+	Delay     int     `yaml:"delay" json:"delay,omitempty"`           // Seconds before the first retry
+	Backoff   float64 `yaml:"backoff" json:"backoff,omitempty"`       // Delay multiplier per retry, default 2
+	MaxDelay  int     `yaml:"max_delay" json:"max_delay,omitempty"`   // Upper bound of the delay in seconds, 0 means none
+	ExitCodes []int   `yaml:"exit_codes" json:"exit_codes,omitempty"` // Retryable exit codes; empty retries any failure
+}
+
+// validate checks the policy values
+func (p *RetryPolicy) validate() error {
+	if p.Count < 0 || p.Delay < 0 || p.MaxDelay < 0 {
+		return fmt.Errorf("retry count and delays cannot be negative")
+	}
+	if p.Backoff != 0 && p.Backoff < 1 {
+		return fmt.Errorf("retry backoff must be at least 1")
+	}
+	return nil
+}
+
+// attempts returns the total number of attempts allowed
+func (p *RetryPolicy) attempts() int {
+	if p == nil {
+		return 1
+	}
+	return p.Count + 1
+}
+
+// delayBefore returns how long to wait before the given attempt (2 or later)
+func (p *RetryPolicy) delayBefore(attempt int) time.Duration {
+	backoff := p.Backoff
+	if backoff == 0 {
+		backoff = defaultRetryBackoff
+	}
+
+	delay := time.Duration(p.Delay) * time.Second
+	for i := 2; i < attempt; i++ {
+		delay = time.Duration(float64(delay) * backoff)
+	}
+	if maxDelay := time.Duration(p.MaxDelay) * time.Second; maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+// retryable reports whether a failure may be retried. Timeouts count as
+// retryable only when the policy does not restrict exit codes; failures to
+// start the step at all (e.g. a missing script) are never retried.
+func (p *RetryPolicy) retryable(err error) bool {
+	var timeoutErr *stepTimeoutError
+	if errors.As(err, &timeoutErr) {
+		return len(p.ExitCodes) == 0
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	if len(p.ExitCodes) == 0 {
+		return true
+	}
+	for _, code := range p.ExitCodes {
+		if exitErr.ExitCode() == code {
+			return true
+		}
+	}
+	return false
+}
+
+// =============================================================================
+// Retry Execution
+// =============================================================================
+
+// runStepWithRetry runs a step process, retrying the failures its retry
+// policy allows. onAttempt is called before every attempt; when retries are
+// possible, log messages are tagged with the attempt number.
+func (bm *BuildManager) runStepWithRetry(ctx context.Context, run *buildRun, step PipelineStep, workspace string, env []string, logFunc func(LogMessage), onAttempt func(attempt int)) error {
+	attempts := step.Retry.attempts()
+	for attempt := 1; ; attempt++ {
+		onAttempt(attempt)
+
+		attemptLog := logFunc
+		if attempts > 1 {
+			attemptLog = func(msg LogMessage) {
+				msg.Attempt = attempt
+				logFunc(msg)
+			}
+		}
+
+		err := bm.runStepProcess(ctx, run, step, workspace, env, attemptLog)
+		if err == nil || attempt >= attempts || ctx.Err() != nil || !step.Retry.retryable(err) {
+			return err
+		}
+
+		delay := step.Retry.delayBefore(attempt + 1)
+		attemptLog(newLogMessage(fmt.Sprintf("🔁 第 %d/%d 次嘗試失敗: %v，%s 後重試", attempt, attempts, err, delay), "warning"))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// =============================================================================
+// Rollback
+// =============================================================================
+
+// RollbackAction restores the previous deployment after a deploy step fails
+type RollbackAction struct {
+	Script  string            `yaml:"script" json:"script,omitempty"`
+	Command string            `yaml:"command" json:"command,omitempty"`
+	Env     map[string]string `yaml:"env" json:"env,omitempty"`
+	Timeout int               `yaml:"timeout" json:"timeout,omitempty"` // Seconds, 0 uses build.step_timeout_seconds
+}
+
+// validate checks that the rollback runs exactly one script or command
+func (a *RollbackAction) validate() error {
+	if a.Script == "" && a.Command == "" {
+		return fmt.Errorf("rollback needs a script or command")
+	}
+	if a.Script != "" && a.Command != "" {
+		return fmt.Errorf("rollback has both a script and a command")
+	}
+	if a.Timeout < 0 {
+		return fmt.Errorf("rollback has a negative timeout")
+	}
+	return nil
+}
+
+// executeRollback runs the rollback of a failed deploy step, passing the
+// previous successful deployment of the branch to the same environment from
+// build history to the script as ROLLBACK_BUILD_ID, ROLLBACK_COMMIT and
+// ROLLBACK_VERSION. The outcome is recorded on the deploy step. Nothing is run
+// when there was no earlier deployment or the rollback script does not exist
+// in the workspace.
+func (bm *BuildManager) executeRollback(run *buildRun, step PipelineStep, workspace string) {
+	action := step.Rollback
+	logFunc := run.stepLogger(step)
+	now := time.Now()
+	result := RollbackResult{Status: BuildStatusRunning, StartedAt: &now}
+
+	finish := func(status BuildStatus, errMsg string) {
+		finishedAt := time.Now()
+		result.Status = status
+		result.FinishedAt = &finishedAt
+		result.Error = errMsg
+		run.setRollback(step.Name, result)
+	}
+
+	previous, found := bm.history.LastDeployment(run.req.GitConfig, run.req.Branch, run.req.Environment, run.build.ID())
+	if !found {
+		logFunc(newLogMessage("⚠️ 沒有先前成功的部署，略過回滾", "warning"))
+		finish(BuildStatusSkipped, "no previous deployment")
+		return
+	}
+	result.TargetBuildID = previous.ID
+	result.TargetCommit = previous.CommitHash
+	result.TargetVersion = previous.Version
+
+	if action.Script != "" {
+		if _, err := os.Stat(filepath.Join(workspace, action.Script)); err != nil {
+			logFunc(newLogMessage(fmt.Sprintf("⚠️ 找不到回滾腳本 %s，略過回滾", action.Script), "warning"))
+			finish(BuildStatusSkipped, fmt.Sprintf("script not found: %s", action.Script))
+			return
+		}
+	}
+
+	run.setRollback(step.Name, result)
+	logFunc(newLogMessage(fmt.Sprintf("↩️ 回滾至構建 %s (版本 %s, commit %s)", previous.ID, previous.Version, shortHash(previous.CommitHash)), "warning"))
+
+	rollbackStep := PipelineStep{
+		Name:    step.Name,
+		Type:    StepTypeScript,
+		Script:  action.Script,
+		Command: action.Command,
+		Env:     action.Env,
+		Timeout: action.Timeout,
+	}
+	env := append(run.stepEnv(rollbackStep),
+		"ROLLBACK_BUILD_ID="+previous.ID,
+		"ROLLBACK_COMMIT="+previous.CommitHash,
+		"ROLLBACK_VERSION="+previous.Version,
+	)
+
+	err := bm.runStepProcess(run.build.Context(), run, rollbackStep, workspace, env, logFunc)
+	switch {
+	case err == nil:
+		logFunc(newLogMessage("✅ 回滾完成", "success"))
+		finish(BuildStatusCompleted, "")
+	case run.build.Context().Err() != nil:
+		finish(run.build.interruptedStatus(), "")
+	default:
+		logFunc(newLogMessage(fmt.Sprintf("❌ 回滾失敗: %v", err), "error"))
+		finish(failureStatus(err), err.Error())
+	}
+}
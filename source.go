@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// =============================================================================
+// Build Sources
+// =============================================================================
+
+// Ref types a build can be requested for
+const (
+	RefTypeBranch = "branch" // Head of a branch
+	RefTypeTag    = "tag"    // Commit a tag points to
+	RefTypeCommit = "commit" // Specific commit of a branch
+)
+
+// pinSource resolves the ref a build request names to the commit the build
+// will use from start to finish, storing it in req.Commit. The mirror is
+// fetched first so a branch build pins the head at request time; later
+// pushes to the branch do not change what the build checks out.
+func (gm *GitManager) pinSource(req *BuildRequest) error {
+	if err := gm.Fetch(); err != nil {
+		return fmt.Errorf("failed to fetch repository: %v", err)
+	}
+
+	var commit string
+	var err error
+	switch req.RefType {
+	case "", RefTypeBranch:
+		req.RefType = RefTypeBranch
+		commit, err = gm.ResolveBranch(req.Branch)
+	case RefTypeTag:
+		commit, err = gm.ResolveTag(req.Branch)
+	case RefTypeCommit:
+		commit, err = gm.resolveBranchCommit(req.Branch, req.Commit)
+	default:
+		return fmt.Errorf("unknown ref type %q, expected %s, %s or %s", req.RefType, RefTypeBranch, RefTypeTag, RefTypeCommit)
+	}
+	if err != nil {
+		return err
+	}
+
+	req.Commit = commit
+	return nil
+}
+
+// ResolveTag returns the commit a tag points to in the mirror
+func (gm *GitManager) ResolveTag(tagName string) (string, error) {
+	output, err := gm.mirrorGit("rev-parse", "--verify", "--quiet", "refs/tags/"+tagName+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("tag %s not found", tagName)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// resolveBranchCommit expands a (possibly abbreviated) commit SHA and checks
+// that the commit is part of the branch
+func (gm *GitManager) resolveBranchCommit(branchName, sha string) (string, error) {
+	if !isCommitSHA(sha) {
+		return "", fmt.Errorf("invalid commit SHA %q", sha)
+	}
+
+	commit, err := gm.ResolveRef(sha)
+	if err != nil {
+		return "", fmt.Errorf("commit %s not found", sha)
+	}
+
+	if _, err := gm.mirrorGit("merge-base", "--is-ancestor", commit, "refs/heads/"+branchName); err != nil {
+		return "", fmt.Errorf("commit %s is not on branch %s", shortHash(commit), branchName)
+	}
+	return commit, nil
+}
+
+// isCommitSHA reports whether s looks like a full or abbreviated commit SHA
+func isCommitSHA(s string) bool {
+	if len(s) < 7 || len(s) > 40 {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') && (c < 'A' || c > 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// sourceLabel describes what a build request builds for log messages
+func (req BuildRequest) sourceLabel() string {
+	switch req.RefType {
+	case RefTypeTag:
+		return fmt.Sprintf("標籤 %s", req.Branch)
+	case RefTypeCommit:
+		return fmt.Sprintf("分支 %s 的 commit %s", req.Branch, shortHash(req.Commit))
+	default:
+		return fmt.Sprintf("分支 %s", req.Branch)
+	}
+}
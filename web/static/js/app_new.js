@@ -1,10 +1,16 @@
 // Global variables
 let currentBranch = '';
+let currentRefType = 'branch';
 let currentBuildId = '';
 let currentGitConfig = '';
+let currentBranches = [];
 let currentTab = 'release-notes';
 let ws = null;
 
+// Steps of the watched build, for the step graph
+let currentPipeline = [];
+let stepStates = {};
+
 // UI state
 let sidebarCollapsed = false;
 let bottomPanelCollapsed = false;
@@ -71,43 +77,41 @@ async function onGitConfigChange() {
     hideBranchInfo();
 }
 
+// Fetch the repository mirror of the selected git config and reload its branches
+async function refreshGitConfig() {
+    if (!currentGitConfig) return;
+    try {
+        const response = await fetch(`/api/git-configs/${encodeURIComponent(currentGitConfig)}/fetch`, { method: 'POST' });
+        if (!response.ok) {
+            addLogMessage(`抓取遠端倉庫失敗: ${await response.text()}`, 'error');
+            return;
+        }
+        addLogMessage(`已從遠端抓取 ${currentGitConfig}`, 'success');
+        await loadBranches(currentGitConfig);
+    } catch (error) {
+        console.error('Failed to fetch repository:', error);
+        addLogMessage('抓取遠端倉庫失敗', 'error');
+    }
+}
+
 // Load available branches for selected git config
 async function loadBranches(gitConfig) {
     try {
         addLogMessage(`正在載入 ${gitConfig} 的分支列表...`, 'info');
         
-        const response = await fetch(`/api/branches/${gitConfig}`);
+        const [response, tagsResponse] = await Promise.all([
+            fetch(`/api/branches/${gitConfig}`),
+            fetch(`/api/tags/${gitConfig}`)
+        ]);
         const data = await response.json();
+        const tags = tagsResponse.ok ? await tagsResponse.json() : [];
         
-        const branchList = document.getElementById('branchList');
-        branchList.innerHTML = '';
-        
-        const branches = Array.isArray(data) ? data : (data.branches || []);
+        currentBranches = (Array.isArray(data) ? data : (data.branches || [])).concat(tags);
+        renderBranches();
         
-        if (branches.length > 0) {
-            branches.forEach(branch => {
-                const branchName = branch.name || branch;
-                const date = branch.date || '';
-                const hash = branch.commit_hash || branch.commitHash || '';
-                const isRelease = branch.is_release || false;
-                
-                const branchItem = document.createElement('div');
-                branchItem.className = 'branch-item';
-                branchItem.innerHTML = `
-                    <div class="branch-name">${branchName}</div>
-                    <div class="branch-meta">
-                        ${date ? `<span><i class="fas fa-calendar"></i> ${date}</span>` : ''}
-                        ${hash ? `<span><i class="fas fa-code-commit"></i> ${hash}</span>` : ''}
-                        <span class="branch-tag ${isRelease ? 'release' : ''}">${isRelease ? 'Release' : 'Dev'}</span>
-                    </div>
-                `;
-                branchItem.onclick = () => selectBranch(branchName);
-                branchList.appendChild(branchItem);
-            });
-            
-            addLogMessage(`成功載入 ${branches.length} 個分支`, 'success');
+        if (currentBranches.length > 0) {
+            addLogMessage(`成功載入 ${currentBranches.length} 個分支`, 'success');
         } else {
-            branchList.innerHTML = '<div class="branch-placeholder">沒有找到可用的分支</div>';
             addLogMessage('沒有找到可用的分支', 'warning');
         }
     } catch (error) {
@@ -117,8 +121,58 @@ async function loadBranches(gitConfig) {
     }
 }
 
-// Handle branch selection
-async function selectBranch(branch) {
+// Render the loaded branches with the sidebar filter and sort order applied
+function renderBranches() {
+    const branchList = document.getElementById('branchList');
+    const filter = document.getElementById('branchFilter').value.trim().toLowerCase();
+    const sort = document.getElementById('branchSort').value;
+    const refType = document.getElementById('branchType').value;
+    
+    const branches = currentBranches.filter(branch => (!refType || branch.ref_type === refType) && (!filter ||
+        [branch.name, branch.author, branch.description].some(text => (text || '').toLowerCase().includes(filter))));
+    
+    const comparators = {
+        date: (a, b) => new Date(b.committed_at || 0) - new Date(a.committed_at || 0),
+        name: (a, b) => a.name.localeCompare(b.name),
+        ahead: (a, b) => (b.ahead || 0) - (a.ahead || 0),
+        behind: (a, b) => (b.behind || 0) - (a.behind || 0)
+    };
+    branches.sort(comparators[sort] || comparators.date);
+    
+    branchList.innerHTML = '';
+    if (branches.length === 0) {
+        branchList.innerHTML = `<div class="branch-placeholder">${currentBranches.length ? '沒有符合條件的分支' : '沒有找到可用的分支'}</div>`;
+        return;
+    }
+    
+    branches.forEach(branch => {
+        const branchName = branch.name;
+        const hash = branch.commit_hash || '';
+        const isRelease = branch.is_release || false;
+        const isTag = branch.ref_type === 'tag';
+        const compared = branch.base_branch && (isTag || branch.base_branch !== branchName);
+        
+        const branchItem = document.createElement('div');
+        branchItem.className = `branch-item ${branchName === currentBranch && branch.ref_type === currentRefType ? 'selected' : ''}`;
+        branchItem.innerHTML = `
+            <div class="branch-name">${isTag ? '<i class="fas fa-tag"></i> ' : ''}${escapeHtml(branchName)}</div>
+            ${branch.description ? `<div class="branch-subject" title="${escapeHtml(branch.description)}">${escapeHtml(branch.description)}</div>` : ''}
+            <div class="branch-meta">
+                ${branch.date ? `<span><i class="fas fa-calendar"></i> ${branch.date}</span>` : ''}
+                ${branch.author ? `<span><i class="fas fa-user"></i> ${escapeHtml(branch.author)}</span>` : ''}
+                ${hash ? `<span><i class="fas fa-code-commit"></i> ${hash}</span>` : ''}
+                ${compared ? `<span title="相對於 ${escapeHtml(branch.base_branch)}">↑${branch.ahead} ↓${branch.behind}</span>` : ''}
+                ${isTag ? '<span class="branch-tag tag">Tag</span>' : ''}
+                <span class="branch-tag ${isRelease ? 'release' : ''}">${isRelease ? 'Release' : 'Dev'}</span>
+            </div>
+        `;
+        branchItem.onclick = () => selectBranch(branchName, branch.ref_type);
+        branchList.appendChild(branchItem);
+    });
+}
+
+// Handle branch or tag selection
+async function selectBranch(branch, refType = 'branch') {
     if (!branch || !currentGitConfig) {
         hideBranchInfo();
         return;
@@ -132,7 +186,11 @@ async function selectBranch(branch) {
     event.currentTarget.classList.add('selected');
     
     currentBranch = branch;
-    document.getElementById('selectedBranch').value = branch;
+    currentRefType = refType;
+    document.getElementById('selectedBranch').value = refType === 'tag' ? `${branch} (標籤)` : branch;
+    const commitInput = document.getElementById('buildCommit');
+    commitInput.value = '';
+    commitInput.disabled = refType === 'tag';
     
     addLogMessage(`選擇分支: ${branch}`, 'info');
     
@@ -147,7 +205,7 @@ async function loadBranchInfo(gitConfig, branch) {
         
         // Load release notes
         try {
-            const notesResponse = await fetch(`/api/release-notes/${gitConfig}/${branch}`);
+            const notesResponse = await fetch(`/api/release-notes/${encodeURIComponent(gitConfig)}/${encodeURIComponent(branch)}`);
             if (notesResponse.ok) {
                 const notesData = await notesResponse.json();
                 const notesText = notesData.notes || '沒有發布說明';
@@ -166,7 +224,7 @@ async function loadBranchInfo(gitConfig, branch) {
         
         // Load version information
         try {
-            const versionsResponse = await fetch(`/api/versions/${gitConfig}/${branch}`);
+            const versionsResponse = await fetch(`/api/versions/${encodeURIComponent(gitConfig)}/${encodeURIComponent(branch)}`);
             if (versionsResponse.ok) {
                 const versionsData = await versionsResponse.json();
                 let versionText = '';
@@ -208,17 +266,11 @@ async function loadBranchInfo(gitConfig, branch) {
         }
         
         // Load configuration
-        try {
-            const configResponse = await fetch(`/api/config/${gitConfig}/${branch}`);
-            if (configResponse.ok) {
-                const configData = await configResponse.json();
-                document.getElementById('configInfo').textContent = JSON.stringify(configData, null, 2);
-            } else {
-                document.getElementById('configInfo').textContent = `載入配置失敗 (${configResponse.status})`;
-            }
-        } catch (error) {
-            document.getElementById('configInfo').textContent = '載入配置時發生錯誤';
-        }
+        document.getElementById('configRef').value = '';
+        const configData = await loadConfigInfo(gitConfig, branch, '');
+        renderEnvironments(configData ? (configData.Deployment || {}).Environments || [] : []);
+        
+        await loadPipeline(gitConfig, branch);
         
         addLogMessage(`分支 ${branch} 資訊載入完成`, 'success');
         
@@ -228,12 +280,94 @@ async function loadBranchInfo(gitConfig, branch) {
     }
 }
 
+// Show config.yaml of a branch, at a commit SHA or tag when ref is given
+async function loadConfigInfo(gitConfig, branch, ref) {
+    const configInfo = document.getElementById('configInfo');
+    const query = ref ? `?ref=${encodeURIComponent(ref)}` : '';
+    try {
+        const configResponse = await fetch(`/api/config/${encodeURIComponent(gitConfig)}/${encodeURIComponent(branch)}${query}`);
+        if (configResponse.ok) {
+            const configData = await configResponse.json();
+            configInfo.textContent = JSON.stringify(configData, null, 2);
+            return configData;
+        }
+        configInfo.textContent = `載入配置失敗 (${configResponse.status})`;
+    } catch (error) {
+        configInfo.textContent = '載入配置時發生錯誤';
+    }
+    return null;
+}
+
+// Show the selected branch's configuration at the ref entered in the config tab
+async function loadConfigAt() {
+    if (!currentGitConfig || !currentBranch) return;
+    const ref = document.getElementById('configRef').value.trim();
+    if (await loadConfigInfo(currentGitConfig, currentBranch, ref) && ref) {
+        addLogMessage(`已載入分支 ${currentBranch} 於 ${ref} 的配置`, 'info');
+    }
+}
+
+// Show the configuration a build ran with
+function viewBuildConfig(commit) {
+    document.getElementById('configRef').value = commit;
+    switchTab('config-info');
+    loadConfigAt();
+}
+
+// Fill the deployment environment selector; the first environment is the default
+function renderEnvironments(environments) {
+    const select = document.getElementById('deployEnvironment');
+    select.innerHTML = environments.length
+        ? environments.map(env => `<option value="${escapeHtml(env)}">${escapeHtml(env)}</option>`).join('')
+        : '<option value="">未設定環境</option>';
+    select.disabled = environments.length === 0;
+}
+
+// Load the pipeline steps the branch defines, at a commit SHA when ref is given
+async function loadPipeline(gitConfig, branch, ref) {
+    const container = document.getElementById('pipelineSteps');
+    const query = ref ? `?ref=${encodeURIComponent(ref)}` : '';
+    try {
+        const response = await fetch(`/api/pipeline/${encodeURIComponent(gitConfig)}/${encodeURIComponent(branch)}${query}`);
+        if (!response.ok) {
+            const message = await response.text();
+            container.innerHTML = `<div class="branch-placeholder">載入構建步驟失敗: ${escapeHtml(message)}</div>`;
+            addLogMessage(`載入構建步驟失敗 (${response.status})`, 'error');
+            return;
+        }
+        renderPipelineSteps(await response.json());
+    } catch (error) {
+        console.error('Failed to load pipeline:', error);
+        container.innerHTML = '<div class="branch-placeholder">載入構建步驟失敗</div>';
+    }
+}
+
+// Render one checkbox per pipeline step; optional steps start unchecked
+function renderPipelineSteps(steps) {
+    const icons = {
+        pull: 'fa-download',
+        build: 'fa-hammer',
+        push: 'fa-cloud-upload-alt',
+        deploy: 'fa-rocket',
+        script: 'fa-terminal'
+    };
+    
+    document.getElementById('pipelineSteps').innerHTML = steps.map(step => `
+        <label class="checkbox-item" title="${escapeHtml(step.script || step.command || '')}">
+            <input type="checkbox" name="pipelineStep" value="${escapeHtml(step.name)}" ${step.optional ? '' : 'checked'}>
+            <span class="checkbox-label">
+                <i class="fas ${icons[step.type] || icons.script}"></i> ${escapeHtml(step.description || step.name)}
+            </span>
+        </label>
+    `).join('');
+}
+
 // Show branch information sections
 function showBranchInfo() {
     document.getElementById('contentPlaceholder').style.display = 'none';
     
     // Show tab content
-    document.querySelectorAll('.tab-content').forEach(content => {
+    document.querySelectorAll('.tab-content:not(.tab-content-global)').forEach(content => {
         if (content.id !== 'contentPlaceholder') {
             content.style.display = 'block';
         }
@@ -245,10 +379,12 @@ function showBranchInfo() {
 
 // Hide branch information sections
 function hideBranchInfo() {
-    document.getElementById('contentPlaceholder').style.display = 'block';
+    if (!isGlobalTab(currentTab)) {
+        document.getElementById('contentPlaceholder').style.display = 'block';
+    }
     
     // Hide tab content but keep tabs visible
-    document.querySelectorAll('.tab-content').forEach(content => {
+    document.querySelectorAll('.tab-content:not(.tab-content-global)').forEach(content => {
         if (content.id !== 'contentPlaceholder') {
             content.style.display = 'none';
         }
@@ -280,15 +416,30 @@ function switchTab(tabName) {
     
     document.querySelector(`[onclick="switchTab('${tabName}')"]`).classList.add('active');
     
-    // Update tab content - only show if branch is selected
-    if (currentBranch) {
+    // Update tab content - only show if branch is selected (global tabs are always available)
+    if (currentBranch || isGlobalTab(tabName)) {
         document.querySelectorAll('.tab-content').forEach(content => {
             content.classList.remove('active');
         });
         
         document.getElementById(`${tabName}-content`).classList.add('active');
         document.getElementById('contentPlaceholder').style.display = 'none';
+    } else {
+        document.querySelectorAll('.tab-content-global').forEach(content => {
+            content.classList.remove('active');
+        });
+        document.getElementById('contentPlaceholder').style.display = 'block';
     }
+    
+    if (tabName === 'build-history') {
+        loadBuildHistory();
+    }
+}
+
+// Check whether a tab does not depend on the selected branch
+function isGlobalTab(tabName) {
+    const content = document.getElementById(`${tabName}-content`);
+    return content !== null && content.classList.contains('tab-content-global');
 }
 
 // Setup event listeners
@@ -298,6 +449,21 @@ function setupEventListeners() {
     
     startBuildBtn.addEventListener('click', handleBuildStart);
     stopBuildBtn.addEventListener('click', handleBuildStop);
+    
+    // Remember the user name between sessions
+    const userInput = document.getElementById('buildUser');
+    userInput.value = localStorage.getItem('buildUser') || '';
+    userInput.addEventListener('change', () => {
+        localStorage.setItem('buildUser', userInput.value.trim());
+    });
+    
+    // A commit build runs the pipeline declared at that commit
+    const commitInput = document.getElementById('buildCommit');
+    commitInput.addEventListener('change', () => {
+        if (currentGitConfig && currentBranch) {
+            loadPipeline(currentGitConfig, currentBranch, commitInput.value.trim());
+        }
+    });
 }
 
 // Handle build start
@@ -307,11 +473,8 @@ async function handleBuildStart() {
         return;
     }
     
-    const steps = [];
-    if (document.getElementById('pullRepos').checked) steps.push('pull');
-    if (document.getElementById('buildImages').checked) steps.push('build');
-    if (document.getElementById('pushHarbor').checked) steps.push('push');
-    if (document.getElementById('deploy').checked) steps.push('deploy');
+    const steps = Array.from(document.querySelectorAll('input[name="pipelineStep"]:checked'))
+        .map(input => input.value);
     
     if (steps.length === 0) {
         addLogMessage('請至少選擇一個構建步驟', 'error');
@@ -326,18 +489,23 @@ async function handleBuildStart() {
         }
         
         if (ws && ws.readyState === WebSocket.OPEN) {
+            // A tag builds the commit it points to; a branch builds its head or the commit entered
+            const commit = document.getElementById('buildCommit').value.trim();
+            const refType = currentRefType === 'tag' ? 'tag' : (commit ? 'commit' : 'branch');
             const buildRequest = {
+                action: 'build',
                 gitConfig: currentGitConfig,
                 branch: currentBranch,
-                pullRepos: steps.includes('pull'),
-                buildImages: steps.includes('build'),
-                pushHarbor: steps.includes('push'),
-                deploy: steps.includes('deploy')
+                refType: refType,
+                commit: refType === 'commit' ? commit : '',
+                steps: steps,
+                environment: document.getElementById('deployEnvironment').value,
+                user: document.getElementById('buildUser').value.trim()
             };
             
             ws.send(JSON.stringify(buildRequest));
             updateBuildUI(true);
-            addLogMessage(`開始構建 ${currentBranch} 分支...`, 'info');
+            addLogMessage(`開始構建 ${currentBranch} ${refType === 'tag' ? '標籤' : '分支'}${commit && refType === 'commit' ? ` 的 commit ${commit}` : ''}...`, 'info');
             
             // Switch to build config tab to show progress
             switchTab('build-config');
@@ -352,10 +520,17 @@ async function handleBuildStart() {
 
 // Handle build stop
 function handleBuildStop() {
-    if (currentBuildId) {
-        addLogMessage('停止構建功能尚未實作', 'warning');
+    if (!currentBuildId) {
+        updateBuildUI(false);
+        return;
+    }
+    
+    if (ws && ws.readyState === WebSocket.OPEN) {
+        ws.send(JSON.stringify({ action: 'stop', buildId: currentBuildId }));
+        document.getElementById('stopBuild').disabled = true;
+    } else {
+        addLogMessage('WebSocket 連接失敗，無法停止構建', 'error');
     }
-    updateBuildUI(false);
 }
 
 // Handle refresh
@@ -478,6 +653,11 @@ function connectWebSocket() {
     
     ws.onopen = function() {
         addLogMessage('WebSocket 連接已建立', 'success');
+        
+        // Resume watching the current build after a reconnect
+        if (currentBuildId && document.getElementById('stopBuild').disabled === false) {
+            ws.send(JSON.stringify({ action: 'subscribe', buildId: currentBuildId }));
+        }
     };
     
     ws.onmessage = function(event) {
@@ -505,12 +685,35 @@ function connectWebSocket() {
 
 // Handle WebSocket messages
 function handleWebSocketMessage(data) {
-    if (data.type === 'log') {
-        addLogMessage(data.data.message, data.data.type);
+    // Ignore events from builds other than the one being watched
+    if (data.type !== 'build' && data.buildId && data.buildId !== currentBuildId) {
+        return;
+    }
+    
+    if (data.type === 'build') {
+        currentBuildId = data.data.id;
+        const active = ['pending', 'queued', 'running', 'awaiting_approval'].includes(data.data.status);
+        updateBuildUI(active);
+        updateProgress(data.data.progress || 0);
+        addLogMessage(`${active ? '正在觀看' : '載入'}構建: ${currentBuildId}`, 'info');
+        currentPipeline = data.data.pipeline || [];
+        stepStates = {};
+        (data.data.steps || []).forEach(step => { stepStates[step.name] = step; });
+        renderStepGraph();
+    } else if (data.type === 'log') {
+        addLogMessage(data.data.message, data.data.type, data.data.stream, data.data.timestamp, logSource(data.data));
+    } else if (data.type === 'logs') {
+        // Backlog of a build joined while running or replayed after it finished
+        data.data.forEach(msg => addLogMessage(msg.message, msg.type, msg.stream, msg.timestamp, logSource(msg)));
+    } else if (data.type === 'step') {
+        stepStates[data.data.name] = data.data;
+        renderStepGraph();
     } else if (data.type === 'progress') {
         updateProgress(data.data.progress);
     } else if (data.type === 'status') {
         updateBuildStatus(data.data);
+    } else if (data.type === 'queue') {
+        updateQueuePosition(data.data);
     }
 }
 
@@ -541,16 +744,381 @@ function updateProgress(progress) {
 
 // Update build status
 function updateBuildStatus(statusData) {
+    if (statusData.build_id && statusData.build_id !== currentBuildId) {
+        return;
+    }
+
     if (statusData.status === 'completed') {
         updateBuildUI(false);
-        addLogMessage('🎉 構建完成！', 'success');
         updateProgress(100);
     } else if (statusData.status === 'failed') {
         updateBuildUI(false);
-        addLogMessage('❌ 構建失敗！', 'error');
+        addLogMessage(`❌ 構建失敗！${statusData.error ? ' ' + statusData.error : ''}`, 'error');
+    } else if (statusData.status === 'timed_out') {
+        updateBuildUI(false);
+        addLogMessage(`⏰ 構建逾時！${statusData.error ? ' ' + statusData.error : ''}`, 'error');
+    } else if (statusData.status === 'cancelled') {
+        updateBuildUI(false);
+    } else if (statusData.status === 'awaiting_approval') {
+        loadBuildHistory(); // Show the approve and reject buttons
     }
 }
 
+// Render the watched build's steps as columns of the dependency graph
+function renderStepGraph() {
+    const graph = document.getElementById('stepGraph');
+    const names = new Set(currentPipeline.map(step => step.name));
+    const hasPull = currentPipeline.some(step => step.type === 'pull');
+    
+    // A step's column is one past the deepest selected step it waits for
+    const levels = {};
+    currentPipeline.forEach(step => {
+        let level = 0;
+        (step.depends_on || []).forEach(dep => {
+            if (names.has(dep)) level = Math.max(level, levels[dep] + 1);
+        });
+        if (hasPull && step.type !== 'pull') level = Math.max(level, 1);
+        levels[step.name] = level;
+    });
+    
+    const columns = [];
+    currentPipeline.forEach(step => {
+        (columns[levels[step.name]] = columns[levels[step.name]] || []).push(step);
+    });
+    
+    graph.innerHTML = columns.filter(Boolean).map(column => `
+        <div class="step-graph-column">
+            ${column.map(step => {
+                const state = stepStates[step.name] || {};
+                const status = state.status || 'pending';
+                const deps = (step.depends_on || []).filter(dep => names.has(dep));
+                const platforms = (state.platforms || []).map(platform => `
+                    <span class="status-badge status-${platform.status}" title="${escapeHtml(platform.error || '')}">${escapeHtml(platform.platform)}${platform.attempts > 1 ? ` ×${platform.attempts}` : ''}</span>
+                `).join('');
+                return `
+                    <div class="step-node status-${status}" title="${escapeHtml(state.error || '')}">
+                        <div>
+                            <div>${escapeHtml(step.name)}</div>
+                            ${deps.length ? `<div class="step-node-deps">← ${escapeHtml(deps.join(', '))}</div>` : ''}
+                            ${platforms ? `<div class="step-node-platforms">${platforms}</div>` : ''}
+                            ${state.rollback ? `<div class="step-node-platforms">${rollbackBadge(state.rollback)}</div>` : ''}
+                        </div>
+                        <span class="status-badge status-${status}">${status}${state.attempts > 1 ? ` (第 ${state.attempts} 次)` : ''}</span>
+                    </div>
+                `;
+            }).join('')}
+        </div>
+    `).join('');
+}
+
+// Show the outcome of a rollback and the build it restored
+function rollbackBadge(rollback) {
+    const target = rollback.target_build_id ? `還原至 ${rollback.target_build_id} ${rollback.target_version || ''}` : '';
+    return `<span class="status-badge status-${rollback.status}" title="${escapeHtml(rollback.error || target)}">↩ 回滾 ${rollback.status}</span>`;
+}
+
+// Describe which step (and matrix platform) produced a log message
+function logSource(msg) {
+    if (!msg.step) return '';
+    const source = msg.platform ? `${msg.step} ${msg.platform}` : msg.step;
+    return msg.attempt ? `${source} #${msg.attempt}` : source;
+}
+
+// Show the position of the watched build in the queue
+function updateQueuePosition(queueData) {
+    if (queueData.position > 0) {
+        addLogMessage(`⏳ 構建排隊中，目前第 ${queueData.position} 位`, 'info');
+    } else {
+        addLogMessage('🚀 構建已離開佇列，開始執行', 'info');
+    }
+}
+
+// Load build history using the current filters
+async function loadBuildHistory() {
+    const params = new URLSearchParams();
+    if (currentGitConfig) params.set('gitConfig', currentGitConfig);
+    
+    const filters = {
+        branch: document.getElementById('historyBranch').value.trim(),
+        status: document.getElementById('historyStatus').value,
+        user: document.getElementById('historyUser').value.trim(),
+        from: document.getElementById('historyFrom').value,
+        to: document.getElementById('historyTo').value
+    };
+    for (const [key, value] of Object.entries(filters)) {
+        if (value) params.set(key, value);
+    }
+    
+    const tbody = document.getElementById('historyTableBody');
+    try {
+        const response = await fetch(`/api/builds?${params.toString()}`);
+        if (!response.ok) {
+            const message = await response.text();
+            tbody.innerHTML = `<tr><td colspan="10" class="history-empty">載入構建歷史失敗: ${escapeHtml(message)}</td></tr>`;
+            return;
+        }
+        renderBuildHistory(await response.json());
+    } catch (error) {
+        console.error('Failed to load build history:', error);
+        tbody.innerHTML = '<tr><td colspan="10" class="history-empty">載入構建歷史失敗</td></tr>';
+    }
+}
+
+// Render build history rows
+function renderBuildHistory(records) {
+    const tbody = document.getElementById('historyTableBody');
+    
+    if (!records || records.length === 0) {
+        tbody.innerHTML = '<tr><td colspan="10" class="history-empty">沒有符合條件的構建記錄</td></tr>';
+        return;
+    }
+    
+    tbody.innerHTML = records.map(record => {
+        const req = record.request || {};
+        const steps = (record.steps || [])
+            .map(step => `<span class="status-badge status-${step.status}">${escapeHtml(step.name)}${step.attempts > 1 ? ` ×${step.attempts}` : ''}</span>${step.rollback ? ' ' + rollbackBadge(step.rollback) : ''}`)
+            .join(' ');
+        const startedAt = record.started_at || record.created_at;
+        
+        return `
+            <tr>
+                <td>${escapeHtml(record.id)}</td>
+                <td>${escapeHtml(req.gitConfig || '')}</td>
+                <td>${req.refType === 'tag' ? '<i class="fas fa-tag"></i> ' : ''}${escapeHtml(req.branch || '')}${req.environment ? ` → ${escapeHtml(req.environment)}` : ''}</td>
+                <td>${escapeHtml((record.commit_hash || '').substring(0, 8))}</td>
+                <td>${steps}</td>
+                <td><span class="status-badge status-${record.status}">${record.status}${record.queue_position ? ` #${record.queue_position}` : ''}</span>${approvalBadge(record.approval)}</td>
+                <td>${escapeHtml(req.user || '-')}</td>
+                <td>${startedAt ? new Date(startedAt).toLocaleString() : '-'}</td>
+                <td>${formatDuration(record.duration_ms)}</td>
+                <td class="history-actions">
+                    ${['pending', 'queued', 'running', 'awaiting_approval'].includes(record.status) ? `
+                    <button class="panel-btn" title="觀看構建" onclick="watchBuild('${record.id}')">
+                        <i class="fas fa-eye"></i>
+                    </button>` : ''}
+                    ${record.status === 'awaiting_approval' ? `
+                    <button class="panel-btn" title="核准" onclick="decideApproval('${record.id}', true)">
+                        <i class="fas fa-check"></i>
+                    </button>
+                    <button class="panel-btn" title="拒絕" onclick="decideApproval('${record.id}', false)">
+                        <i class="fas fa-ban"></i>
+                    </button>` : ''}
+                    ${record.status === 'queued' ? `
+                    <button class="panel-btn" title="移到佇列最前" onclick="moveQueuedBuild('${record.id}', 1)">
+                        <i class="fas fa-angle-double-up"></i>
+                    </button>
+                    <button class="panel-btn" title="移出佇列" onclick="removeQueuedBuild('${record.id}')">
+                        <i class="fas fa-times"></i>
+                    </button>` : ''}
+                    ${['completed', 'failed', 'cancelled', 'timed_out'].includes(record.status) && record.commit_hash ? `
+                    <button class="panel-btn" title="從步驟重新執行" onclick="rerunBuild('${record.id}')">
+                        <i class="fas fa-redo"></i>
+                    </button>` : ''}
+                    ${isDeployed(record) && req.environment ? `
+                    <button class="panel-btn" title="推進到下一個環境" onclick="promoteBuild('${record.id}')">
+                        <i class="fas fa-level-up-alt"></i>
+                    </button>` : ''}
+                    ${record.commit_hash && req.gitConfig === currentGitConfig && req.branch === currentBranch ? `
+                    <button class="panel-btn" title="查看此 commit 的配置" onclick="viewBuildConfig('${record.commit_hash}')">
+                        <i class="fas fa-file-code"></i>
+                    </button>` : ''}
+                    <button class="panel-btn" title="回放日誌" onclick="replayBuildLog('${record.id}')">
+                        <i class="fas fa-play-circle"></i>
+                    </button>
+                    <a class="panel-btn" title="下載日誌" href="/api/build/${encodeURIComponent(record.id)}/log">
+                        <i class="fas fa-download"></i>
+                    </a>
+                </td>
+            </tr>
+        `;
+    }).join('');
+}
+
+// Watch a build's live events (and its backlog) from any client
+function watchBuild(buildId) {
+    if (!ws || ws.readyState !== WebSocket.OPEN) {
+        addLogMessage('WebSocket 連接失敗，無法觀看構建', 'error');
+        return;
+    }
+    
+    if (currentBuildId && currentBuildId !== buildId) {
+        ws.send(JSON.stringify({ action: 'unsubscribe', buildId: currentBuildId }));
+    }
+    
+    currentBuildId = buildId;
+    document.getElementById('logContainer').innerHTML = '';
+    ws.send(JSON.stringify({ action: 'subscribe', buildId: buildId }));
+    
+    if (bottomPanelCollapsed) {
+        toggleBottomPanel();
+    }
+}
+
+// Move a queued build to a new position in the queue
+async function moveQueuedBuild(buildId, position) {
+    try {
+        const response = await fetch(`/api/queue/${encodeURIComponent(buildId)}/move`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ position: position })
+        });
+        if (!response.ok) {
+            addLogMessage(`調整佇列失敗: ${await response.text()}`, 'error');
+        }
+    } catch (error) {
+        console.error('Failed to move queued build:', error);
+        addLogMessage('調整佇列失敗', 'error');
+    }
+    loadBuildHistory();
+}
+
+// Start a new build repeating a finished build from a chosen step
+async function rerunBuild(buildId) {
+    try {
+        const response = await fetch(`/api/build/status/${encodeURIComponent(buildId)}`);
+        if (!response.ok) throw new Error(await response.text());
+        const record = await response.json();
+        
+        const steps = (record.pipeline || []).filter(step => step.type !== 'pull').map(step => step.name);
+        const failed = (record.steps || []).find(step => step.status !== 'completed' && steps.includes(step.name));
+        const fromStep = prompt(`從哪個步驟重新執行？(${steps.join(', ')})`, failed ? failed.name : steps[0] || '');
+        if (!fromStep) return;
+        
+        const rerun = await fetch(`/api/build/${encodeURIComponent(buildId)}/rerun`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ from_step: fromStep.trim(), user: document.getElementById('buildUser').value.trim() })
+        });
+        if (!rerun.ok) {
+            addLogMessage(`重新執行失敗: ${await rerun.text()}`, 'error');
+            return;
+        }
+        
+        const build = await rerun.json();
+        watchBuild(build.id);
+        addLogMessage(`🔁 已從步驟 ${fromStep} 重新執行構建 ${buildId}，新構建 ${build.id}`, 'info');
+    } catch (error) {
+        console.error('Failed to re-run build:', error);
+        addLogMessage('重新執行失敗', 'error');
+    }
+    loadBuildHistory();
+}
+
+// Show the approval decision of a release build
+function approvalBadge(approval) {
+    if (!approval) return '';
+    const title = [approval.decided_by, approval.comment].filter(Boolean).join(': ');
+    return ` <span class="status-badge status-approval-${approval.status}" title="${escapeHtml(title)}">✋ ${approval.status}${approval.decided_by ? ' ' + escapeHtml(approval.decided_by) : ''}</span>`;
+}
+
+// Approve or reject a release build waiting at its approval gate
+async function decideApproval(buildId, approved) {
+    const user = document.getElementById('buildUser').value.trim();
+    if (!user) {
+        addLogMessage('請先輸入執行者名稱再核准或拒絕', 'error');
+        return;
+    }
+    const comment = prompt(approved ? '核准備註 (可留空)' : '拒絕原因', '');
+    if (comment === null) return;
+    
+    try {
+        const response = await fetch(`/api/build/${encodeURIComponent(buildId)}/${approved ? 'approve' : 'reject'}`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ user: user, comment: comment.trim() })
+        });
+        if (!response.ok) {
+            addLogMessage(`${approved ? '核准' : '拒絕'}失敗: ${await response.text()}`, 'error');
+        }
+    } catch (error) {
+        console.error('Failed to submit approval decision:', error);
+        addLogMessage(`${approved ? '核准' : '拒絕'}失敗`, 'error');
+    }
+    loadBuildHistory();
+}
+
+// Whether every deploy step of a build completed
+function isDeployed(record) {
+    const deploySteps = (record.pipeline || []).filter(step => step.type === 'deploy');
+    return deploySteps.length > 0 && deploySteps.every(step =>
+        (record.steps || []).some(result => result.name === step.name && result.status === 'completed'));
+}
+
+// Redeploy the version of a deployed build to another environment
+async function promoteBuild(buildId) {
+    const environment = prompt('推進到哪個環境？(留空則為下一個環境)', '');
+    if (environment === null) return;
+    
+    try {
+        const response = await fetch(`/api/build/${encodeURIComponent(buildId)}/promote`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ environment: environment.trim(), user: document.getElementById('buildUser').value.trim() })
+        });
+        if (!response.ok) {
+            addLogMessage(`推進失敗: ${await response.text()}`, 'error');
+            return;
+        }
+        
+        const build = await response.json();
+        watchBuild(build.id);
+        addLogMessage(`🚚 構建 ${buildId} 推進到 ${build.request.environment}，新構建 ${build.id}`, 'info');
+    } catch (error) {
+        console.error('Failed to promote build:', error);
+        addLogMessage('推進失敗', 'error');
+    }
+    loadBuildHistory();
+}
+
+// Drop a queued build before it starts
+async function removeQueuedBuild(buildId) {
+    try {
+        const response = await fetch(`/api/queue/${encodeURIComponent(buildId)}`, { method: 'DELETE' });
+        if (!response.ok) {
+            addLogMessage(`移出佇列失敗: ${await response.text()}`, 'error');
+        }
+    } catch (error) {
+        console.error('Failed to remove queued build:', error);
+        addLogMessage('移出佇列失敗', 'error');
+    }
+    loadBuildHistory();
+}
+
+// Replay an archived build log in the log panel with its original types
+async function replayBuildLog(buildId) {
+    try {
+        const response = await fetch(`/api/build/${encodeURIComponent(buildId)}/logs`);
+        if (!response.ok) {
+            addLogMessage(`無法載入構建 ${buildId} 的日誌 (${response.status})`, 'error');
+            return;
+        }
+        
+        const messages = await response.json();
+        document.getElementById('logContainer').innerHTML = '';
+        addLogMessage(`── 回放構建 ${buildId} 的日誌 (${messages.length} 行) ──`, 'info');
+        messages.forEach(msg => {
+            addLogMessage(msg.message, msg.type, msg.stream, msg.timestamp, logSource(msg));
+        });
+        
+        if (bottomPanelCollapsed) {
+            toggleBottomPanel();
+        }
+    } catch (error) {
+        console.error('Failed to replay build log:', error);
+        addLogMessage('回放日誌失敗', 'error');
+    }
+}
+
+// Format a duration in milliseconds as a short human readable string
+function formatDuration(ms) {
+    if (!ms) return '-';
+    const seconds = Math.round(ms / 1000);
+    if (seconds < 60) return `${seconds}s`;
+    const minutes = Math.floor(seconds / 60);
+    if (minutes < 60) return `${minutes}m ${seconds % 60}s`;
+    return `${Math.floor(minutes / 60)}h ${minutes % 60}m`;
+}
+
 // Update content height based on bottom panel state
 function updateContentHeight() {
     const root = document.documentElement;
@@ -558,13 +1126,13 @@ function updateContentHeight() {
 }
 
 // Add log message
-function addLogMessage(message, type = 'info') {
+function addLogMessage(message, type = 'info', stream = '', time = '', step = '') {
     const logContainer = document.getElementById('logContainer');
-    const timestamp = new Date().toLocaleTimeString();
+    const timestamp = time || new Date().toLocaleTimeString();
     
     const logEntry = document.createElement('div');
-    logEntry.className = `log-entry log-${type} fade-in`;
-    logEntry.innerHTML = `<span class="log-time">[${timestamp}]</span> ${message}`;
+    logEntry.className = `log-entry log-${type} fade-in${stream ? ' log-' + stream : ''}`;
+    logEntry.innerHTML = `<span class="log-time">[${timestamp}]</span> ${step ? `<span class="log-step">[${escapeHtml(step)}]</span> ` : ''}${escapeHtml(message)}`;
     
     logContainer.appendChild(logEntry);
     logContainer.scrollTop = logContainer.scrollHeight;
@@ -574,4 +1142,10 @@ function addLogMessage(message, type = 'info') {
     if (entries.length > 1000) {
         entries[0].remove();
     }
-}
\ No newline at end of file
+}
+// Escape HTML special characters so script output is rendered as text
+function escapeHtml(text) {
+    const div = document.createElement('div');
+    div.textContent = text;
+    return div.innerHTML;
+}
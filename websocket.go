@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// =============================================================================
+// Connection Settings
+// =============================================================================
+
+const (
+	// wsWriteWait is the time allowed to write a single message
+	wsWriteWait = 10 * time.Second
+
+	// wsPongWait is the time allowed to read the next pong from the client
+	wsPongWait = 60 * time.Second
+
+	// wsPingPeriod sends pings before the pong deadline expires
+	wsPingPeriod = (wsPongWait * 9) / 10
+
+	// wsMaxMessageSize limits incoming messages
+	wsMaxMessageSize = 64 * 1024
+
+	// wsSendQueueSize bounds the number of messages waiting to be written
+	wsSendQueueSize = 256
+
+	// wsMaxDropped disconnects a client that keeps falling behind
+	wsMaxDropped = 2000
+)
+
+// =============================================================================
+// WebSocket Client
+// =============================================================================
+
+// wsClient owns a WebSocket connection. All writes go through a bounded
+// queue drained by a single writer goroutine, as gorilla/websocket allows
+// only one concurrent writer. When the queue is full, log events are
+// dropped (and the client is told how many); any other event, or too many
+// drops, disconnects the slow client.
+type wsClient struct {
+	conn *websocket.Conn
+	send chan interface{}
+	done chan struct{}
+
+	closeOnce sync.Once
+	dropped   int64
+	totalDrop int64
+}
+
+// newWSClient wraps an upgraded connection and starts its writer goroutine
+func newWSClient(conn *websocket.Conn) *wsClient {
+	c := &wsClient{
+		conn: conn,
+		send: make(chan interface{}, wsSendQueueSize),
+		done: make(chan struct{}),
+	}
+
+	conn.SetReadLimit(wsMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	go c.writePump()
+	return c
+}
+
+// SendEvent queues a build event for the client
+func (c *wsClient) SendEvent(event BuildEvent) {
+	if c.enqueue(event) {
+		return
+	}
+
+	if event.Type != EventLog {
+		log.Printf("WebSocket client too slow, dropping connection (lost %s event)", event.Type)
+		c.close()
+		return
+	}
+
+	total := atomic.AddInt64(&c.totalDrop, 1)
+	if atomic.AddInt64(&c.dropped, 1) == 1 {
+		log.Printf("WebSocket client too slow, dropping log messages (%d so far)", total)
+	}
+	if total >= wsMaxDropped {
+		log.Printf("WebSocket client dropped %d messages, disconnecting", total)
+		c.close()
+	}
+}
+
+// sendLogMessage sends a connection-level log message that is not tied to a build
+func (c *wsClient) sendLogMessage(message, msgType string) {
+	c.SendEvent(BuildEvent{Type: EventLog, Data: newLogMessage(message, msgType)})
+}
+
+// enqueue adds a message to the send queue without blocking
+func (c *wsClient) enqueue(v interface{}) bool {
+	select {
+	case <-c.done:
+		return true // Closed clients silently discard messages
+	default:
+	}
+
+	select {
+	case c.send <- v:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops the writer goroutine, which closes the connection
+func (c *wsClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// writePump writes queued messages and keepalive pings to the connection
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case <-c.done:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
+
+		case msg := <-c.send:
+			if err := c.write(msg); err != nil {
+				log.Printf("WebSocket write error: %v", err)
+				c.close()
+				return
+			}
+
+			// Once caught up, tell the client how many log lines it missed
+			if len(c.send) == 0 {
+				if n := atomic.SwapInt64(&c.dropped, 0); n > 0 {
+					notice := BuildEvent{Type: EventLog, Data: newLogMessage(fmt.Sprintf("⚠️ 連線速度過慢，已略過 %d 則日誌", n), "warning")}
+					if err := c.write(notice); err != nil {
+						c.close()
+						return
+					}
+				}
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// write sends a single JSON message with a write deadline
+func (c *wsClient) write(msg interface{}) error {
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return c.conn.WriteJSON(msg)
+}
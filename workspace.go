@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// =============================================================================
+// Build Workspaces
+// =============================================================================
+
+// workspaceRoot holds one fresh checkout per build
+const workspaceRoot = "build-temp"
+
+// workspacePath returns the workspace directory of a build
+func workspacePath(buildID string) string {
+	return filepath.Join(workspaceRoot, buildID)
+}
+
+// workspaceFor returns the build's workspace, creating it on first use. The
+// workspace is a clone of the repository mirror at the build's commit, so
+// scripts never see artifacts or edits left behind by other builds.
+func (bm *BuildManager) workspaceFor(run *buildRun) (string, error) {
+	run.workspaceMu.Lock()
+	defer run.workspaceMu.Unlock()
+
+	if run.workspace != "" {
+		return run.workspace, nil
+	}
+
+	if !run.git.hasMirror() {
+		return "", fmt.Errorf("branch %s has not been pulled yet, enable the pull step", run.req.Branch)
+	}
+
+	commit := run.build.Snapshot().CommitHash
+	if commit == "" {
+		return "", fmt.Errorf("build %s has no pinned commit", run.build.ID())
+	}
+
+	dir := workspacePath(run.build.ID())
+	if err := run.git.CreateWorkspace(dir, commit); err != nil {
+		return "", err
+	}
+
+	run.workspace = dir
+	run.build.setWorkspace(dir)
+	if version := readVersionTag(dir); version != "" {
+		run.build.setVersion(version)
+	}
+	run.persist()
+	run.log(fmt.Sprintf("📂 建立構建工作區 %s (commit %s)", dir, shortHash(commit)), "info")
+	return dir, nil
+}
+
+// reuseWorkspace lets a build that repeats parent work in the parent's
+// workspace, if it has been retained
+func reuseWorkspace(build *Build, parent BuildRecord) {
+	if parent.Workspace == "" {
+		return
+	}
+	if _, err := os.Stat(parent.Workspace); err == nil {
+		build.setWorkspace(parent.Workspace)
+	}
+}
+
+// releaseWorkspace removes the build's workspace unless it should be kept
+// for the configured retention period
+func (bm *BuildManager) releaseWorkspace(run *buildRun) {
+	run.workspaceMu.Lock()
+	defer run.workspaceMu.Unlock()
+
+	if run.workspace == "" || bm.config.Build.WorkspaceRetentionHours > 0 {
+		return
+	}
+	if err := os.RemoveAll(run.workspace); err != nil {
+		log.Printf("Error removing workspace %s: %v", run.workspace, err)
+	}
+}
+
+// runWorkspaceRetention periodically removes workspaces of finished builds
+// older than the configured retention
+func (bm *BuildManager) runWorkspaceRetention() {
+	retention := time.Duration(bm.config.Build.WorkspaceRetentionHours) * time.Hour
+	for {
+		bm.cleanupWorkspaces(retention)
+		time.Sleep(time.Hour)
+	}
+}
+
+// cleanupWorkspaces removes workspaces not used by an active build, including
+// re-runs working in their parent's workspace, whose last modification is
+// older than retention
+func (bm *BuildManager) cleanupWorkspaces(retention time.Duration) {
+	entries, err := os.ReadDir(workspaceRoot)
+	if err != nil {
+		log.Printf("Error listing workspaces: %v", err)
+		return
+	}
+
+	inUse := make(map[string]bool)
+	for _, build := range bm.builds.Active() {
+		inUse[workspacePath(build.ID())] = true
+		inUse[build.Snapshot().Workspace] = true // Re-runs work in their parent's workspace
+	}
+
+	cutoff := time.Now().Add(-retention)
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || inUse[filepath.Join(workspaceRoot, entry.Name())] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(workspaceRoot, entry.Name())); err != nil {
+			log.Printf("Error removing workspace %s: %v", entry.Name(), err)
+			continue
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		log.Printf("Removed %d build workspaces", removed)
+	}
+}
+
+// readVersionTag returns the Docker tag declared in a checkout's
+// versions.json, or "" when there is none
+func readVersionTag(dir string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "versions.json"))
+	if err != nil {
+		return ""
+	}
+	var versions VersionInfo
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return ""
+	}
+	return versions.Docker.Tag
+}
+
+// shortHash abbreviates a commit hash for display
+func shortHash(hash string) string {
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}